@@ -0,0 +1,149 @@
+package buildpackyml
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ProfileEnvVar names the profile under go.profiles that is merged over the
+// base go: section at staging time.
+const ProfileEnvVar = "GO_BUILD_PROFILE"
+
+// ModuleDirEnvVar names the directory, relative to the build dir, that holds
+// the app's go.mod. It takes precedence over go.module_dir in buildpack.yml.
+const ModuleDirEnvVar = "GO_MODULE_DIR"
+
+// Load reads buildpack.yml from buildDir into config. When $GO_BUILD_PROFILE
+// is set, that profile is merged over the go: section first: maps are merged
+// key by key and every other value, lists included, is replaced. It returns
+// the selected profile and the resolved go: section as YAML.
+func Load(buildDir string, config interface{}) (string, string, error) {
+	profile := os.Getenv(ProfileEnvVar)
+
+	buildpackYAMLPath := filepath.Join(buildDir, "buildpack.yml")
+	exists, err := libbuildpack.FileExists(buildpackYAMLPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !exists {
+		if profile != "" {
+			return "", "", fmt.Errorf("build profile %q is selected but there is no buildpack.yml", profile)
+		}
+		return "", "", nil
+	}
+
+	contents, err := os.ReadFile(buildpackYAMLPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	document := map[interface{}]interface{}{}
+	if err := yaml.Unmarshal(contents, &document); err != nil {
+		return "", "", err
+	}
+
+	goSection, _ := document["go"].(map[interface{}]interface{})
+	if goSection == nil {
+		goSection = map[interface{}]interface{}{}
+	}
+
+	profiles, _ := goSection["profiles"].(map[interface{}]interface{})
+	delete(goSection, "profiles")
+
+	if profile != "" {
+		overrides, ok := profiles[profile].(map[interface{}]interface{})
+		if !ok {
+			return "", "", fmt.Errorf("build profile %q is not defined in buildpack.yml (available: %s)", profile, profileNames(profiles))
+		}
+		goSection = merge(goSection, overrides)
+	}
+	document["go"] = goSection
+
+	resolved, err := yaml.Marshal(document)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := yaml.Unmarshal(resolved, config); err != nil {
+		return "", "", err
+	}
+
+	resolvedGo, err := yaml.Marshal(goSection)
+	if err != nil {
+		return "", "", err
+	}
+
+	return profile, string(resolvedGo), nil
+}
+
+// ModuleDir returns the module directory set by $GO_MODULE_DIR or by
+// go.module_dir in buildpack.yml, cleaned and relative to buildDir. It
+// returns "" when the module is at the root of the build dir.
+func ModuleDir(buildDir string) (string, error) {
+	dir := os.Getenv(ModuleDirEnvVar)
+	if dir == "" {
+		var config struct {
+			Go struct {
+				ModuleDir string `yaml:"module_dir"`
+			} `yaml:"go"`
+		}
+		if _, _, err := Load(buildDir, &config); err != nil {
+			return "", err
+		}
+		dir = config.Go.ModuleDir
+	}
+
+	if dir == "" {
+		return "", nil
+	}
+
+	cleaned := filepath.Clean(dir)
+	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("module dir %q must be inside the app", dir)
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+
+	return cleaned, nil
+}
+
+func merge(base, overrides map[interface{}]interface{}) map[interface{}]interface{} {
+	merged := map[interface{}]interface{}{}
+	for key, value := range base {
+		merged[key] = value
+	}
+
+	for key, value := range overrides {
+		baseMap, baseIsMap := merged[key].(map[interface{}]interface{})
+		overrideMap, overrideIsMap := value.(map[interface{}]interface{})
+		if baseIsMap && overrideIsMap {
+			merged[key] = merge(baseMap, overrideMap)
+		} else {
+			merged[key] = value
+		}
+	}
+
+	return merged
+}
+
+func profileNames(profiles map[interface{}]interface{}) string {
+	if len(profiles) == 0 {
+		return "none"
+	}
+
+	var names []string
+	for name := range profiles {
+		names = append(names, fmt.Sprint(name))
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
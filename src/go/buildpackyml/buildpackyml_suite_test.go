@@ -0,0 +1,13 @@
+package buildpackyml_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestBuildpackyml(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Buildpackyml Suite")
+}
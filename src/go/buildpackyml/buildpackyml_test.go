@@ -0,0 +1,227 @@
+package buildpackyml_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/go-buildpack/src/go/buildpackyml"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Load", func() {
+	type config struct {
+		Go struct {
+			LDFlags map[string]string `yaml:"ldflags"`
+			Tags    []string          `yaml:"tags"`
+			Debug   struct {
+				Enabled bool `yaml:"enabled"`
+			} `yaml:"debug"`
+		} `yaml:"go"`
+	}
+
+	var (
+		buildDir string
+		loaded   config
+		profile  string
+		resolved string
+		err      error
+	)
+
+	BeforeEach(func() {
+		buildDir, err = os.MkdirTemp("", "go-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		loaded = config{}
+
+		oldProfile, wasSet := os.LookupEnv("GO_BUILD_PROFILE")
+		Expect(os.Unsetenv("GO_BUILD_PROFILE")).To(Succeed())
+
+		DeferCleanup(func() {
+			if wasSet {
+				Expect(os.Setenv("GO_BUILD_PROFILE", oldProfile)).To(Succeed())
+			} else {
+				Expect(os.Unsetenv("GO_BUILD_PROFILE")).To(Succeed())
+			}
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+		})
+	})
+
+	JustBeforeEach(func() {
+		profile, resolved, err = buildpackyml.Load(buildDir, &loaded)
+	})
+
+	Context("there is no buildpack.yml", func() {
+		It("leaves the config empty", func() {
+			Expect(err).To(BeNil())
+			Expect(profile).To(BeEmpty())
+			Expect(loaded.Go.LDFlags).To(BeEmpty())
+		})
+
+		Context("a profile is selected", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("GO_BUILD_PROFILE", "prod")).To(Succeed())
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(`build profile "prod" is selected but there is no buildpack.yml`))
+			})
+		})
+	})
+
+	Context("buildpack.yml has profiles", func() {
+		BeforeEach(func() {
+			contents := `---
+go:
+  ldflags:
+    main.env: base
+    main.region: eu
+  tags: [base]
+  profiles:
+    dev:
+      debug:
+        enabled: true
+    prod:
+      ldflags:
+        main.env: prod
+      tags: [prod, metrics]
+`
+			Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte(contents), 0644)).To(Succeed())
+		})
+
+		Context("no profile is selected", func() {
+			It("loads the base config", func() {
+				Expect(err).To(BeNil())
+				Expect(profile).To(BeEmpty())
+				Expect(loaded.Go.LDFlags).To(Equal(map[string]string{"main.env": "base", "main.region": "eu"}))
+				Expect(loaded.Go.Tags).To(Equal([]string{"base"}))
+				Expect(loaded.Go.Debug.Enabled).To(BeFalse())
+			})
+		})
+
+		Context("a profile is selected", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("GO_BUILD_PROFILE", "prod")).To(Succeed())
+			})
+
+			It("merges maps and replaces lists", func() {
+				Expect(err).To(BeNil())
+				Expect(profile).To(Equal("prod"))
+				Expect(loaded.Go.LDFlags).To(Equal(map[string]string{"main.env": "prod", "main.region": "eu"}))
+				Expect(loaded.Go.Tags).To(Equal([]string{"prod", "metrics"}))
+			})
+
+			It("returns the resolved config without the profiles", func() {
+				Expect(resolved).To(ContainSubstring("main.env: prod"))
+				Expect(resolved).To(ContainSubstring("main.region: eu"))
+				Expect(resolved).NotTo(ContainSubstring("profiles"))
+			})
+		})
+
+		Context("a profile that adds a section is selected", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("GO_BUILD_PROFILE", "dev")).To(Succeed())
+			})
+
+			It("adds it to the base config", func() {
+				Expect(err).To(BeNil())
+				Expect(loaded.Go.Debug.Enabled).To(BeTrue())
+				Expect(loaded.Go.Tags).To(Equal([]string{"base"}))
+			})
+		})
+
+		Context("an unknown profile is selected", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("GO_BUILD_PROFILE", "staging")).To(Succeed())
+			})
+
+			It("returns an error listing the available profiles", func() {
+				Expect(err).To(MatchError(`build profile "staging" is not defined in buildpack.yml (available: dev, prod)`))
+			})
+		})
+	})
+})
+
+var _ = Describe("ModuleDir", func() {
+	var (
+		buildDir  string
+		moduleDir string
+		err       error
+	)
+
+	BeforeEach(func() {
+		buildDir, err = os.MkdirTemp("", "go-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		DeferCleanup(os.Setenv, "GO_MODULE_DIR", os.Getenv("GO_MODULE_DIR"))
+		DeferCleanup(os.Setenv, "GO_BUILD_PROFILE", os.Getenv("GO_BUILD_PROFILE"))
+		Expect(os.Unsetenv("GO_MODULE_DIR")).To(Succeed())
+		Expect(os.Unsetenv("GO_BUILD_PROFILE")).To(Succeed())
+
+		DeferCleanup(os.RemoveAll, buildDir)
+	})
+
+	JustBeforeEach(func() {
+		moduleDir, err = buildpackyml.ModuleDir(buildDir)
+	})
+
+	Context("nothing sets a module dir", func() {
+		It("returns the build dir root", func() {
+			Expect(err).To(BeNil())
+			Expect(moduleDir).To(BeEmpty())
+		})
+	})
+
+	Context("buildpack.yml sets a module dir", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("go:\n  module_dir: ./services/api/\n"), 0644)).To(Succeed())
+		})
+
+		It("returns it cleaned", func() {
+			Expect(err).To(BeNil())
+			Expect(moduleDir).To(Equal(filepath.Join("services", "api")))
+		})
+
+		Context("GO_MODULE_DIR is set", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("GO_MODULE_DIR", "services/worker")).To(Succeed())
+			})
+
+			It("prefers the env var", func() {
+				Expect(err).To(BeNil())
+				Expect(moduleDir).To(Equal(filepath.Join("services", "worker")))
+			})
+		})
+	})
+
+	Context("the module dir is the root", func() {
+		BeforeEach(func() {
+			Expect(os.Setenv("GO_MODULE_DIR", "./")).To(Succeed())
+		})
+
+		It("returns the build dir root", func() {
+			Expect(err).To(BeNil())
+			Expect(moduleDir).To(BeEmpty())
+		})
+	})
+
+	Context("the module dir leaves the app", func() {
+		BeforeEach(func() {
+			Expect(os.Setenv("GO_MODULE_DIR", "services/../../shared")).To(Succeed())
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError(`module dir "services/../../shared" must be inside the app`))
+		})
+	})
+
+	Context("the module dir is absolute", func() {
+		BeforeEach(func() {
+			Expect(os.Setenv("GO_MODULE_DIR", "/srv/api")).To(Succeed())
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError(`module dir "/srv/api" must be inside the app`))
+		})
+	})
+})
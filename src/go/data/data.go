@@ -3,14 +3,152 @@ package data
 import (
 	"fmt"
 	"path"
+	"regexp"
+	"sort"
+	"strings"
 )
 
-func ReleaseYAML(mainPackageName string) string {
-	release := `---
-default_process_types:
-    web: ./bin/%s
+// ReleaseYAML lists the start command of each process type.
+func ReleaseYAML(commands map[string]string) string {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	release := "---\ndefault_process_types:\n"
+	for _, name := range names {
+		release += fmt.Sprintf("    %s: %s\n", name, yamlScalar(commands[name]))
+	}
+	return release
+}
+
+func BinaryCommand(binary string) string {
+	return shellWord("./bin/" + binary)
+}
+
+// DebugCommand starts binary under a headless Delve server. The server
+// listens on DLV_PORT, falling back to the port from buildpack.yml, and is
+// reached through cf ssh port forwarding.
+func DebugCommand(binary string, port int) string {
+	return fmt.Sprintf("./bin/dlv exec %s --headless --listen=:${DLV_PORT:-%d} --api-version=2 --accept-multiclient --continue", BinaryCommand(binary), port)
+}
+
+// CrashCaptureCommand starts binary through the crash capture wrapper.
+func CrashCaptureCommand(binary string) string {
+	return fmt.Sprintf("./bin/%s %s", CrashCaptureWrapperName, BinaryCommand(binary))
+}
+
+// StartCommand appends args to command and prefixes it with env. Arguments
+// and values are quoted so that only references to environment variables,
+// such as $PORT, are expanded when the process starts.
+func StartCommand(command string, args []string, env map[string]string) string {
+	var names []string
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var words []string
+	for _, name := range names {
+		words = append(words, name+"="+shellWord(env[name]))
+	}
+
+	words = append(words, command)
+	for _, arg := range args {
+		words = append(words, shellWord(arg))
+	}
+
+	return strings.Join(words, " ")
+}
+
+var (
+	safeShellWord = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+	shellVariable = regexp.MustCompile(`^[A-Za-z_{]`)
+)
+
+func shellWord(word string) string {
+	if safeShellWord.MatchString(word) {
+		return word
+	}
+
+	var quoted strings.Builder
+	quoted.WriteString(`"`)
+	for i, r := range word {
+		switch r {
+		case '\\', '"', '`':
+			quoted.WriteRune('\\')
+		case '$':
+			if !shellVariable.MatchString(word[i+1:]) {
+				quoted.WriteRune('\\')
+			}
+		}
+		quoted.WriteRune(r)
+	}
+	quoted.WriteString(`"`)
+
+	return quoted.String()
+}
+
+// yamlScalar single-quotes value when it would not read back as the same
+// plain YAML scalar. Start commands begin with ./bin/, a quoted binary path
+// or an environment variable name, so a leading double quote and the inner
+// and trailing indicators need checking.
+func yamlScalar(value string) string {
+	if strings.HasPrefix(value, `"`) || strings.Contains(value, ": ") || strings.Contains(value, " #") || strings.HasSuffix(value, ":") {
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	return value
+}
+
+const CrashCaptureWrapperName = "go-crash-capture"
+
+// CrashCaptureScript runs a Go binary with GOTRACEBACK defaulted to all and
+// copies its stderr to a log. When the binary dies with a panic or fatal
+// error, the final trace is saved to $GO_CRASH_DIR/panic.log (crash/ under
+// the app dir by default) and a one-line summary is printed. Signals are
+// forwarded to the binary and its exit status is passed through unchanged.
+func CrashCaptureScript() string {
+	return `#!/usr/bin/env bash
+export GOTRACEBACK="${GOTRACEBACK:-all}"
+
+crash_dir="${GO_CRASH_DIR:-$HOME/crash}"
+work_dir="$(mktemp -d)"
+stderr_log="$work_dir/stderr.log"
+trap 'rm -rf "$work_dir"' EXIT
+
+mkfifo "$work_dir/stderr"
+tee "$stderr_log" < "$work_dir/stderr" >&2 &
+tee_pid=$!
+
+# Background jobs get /dev/null as stdin unless it is redirected explicitly.
+"$@" <&0 2> "$work_dir/stderr" &
+child=$!
+trap 'kill -TERM "$child" 2>/dev/null' TERM
+trap 'kill -INT "$child" 2>/dev/null' INT
+
+while :; do
+  wait "$child"
+  status=$?
+  if ! kill -0 "$child" 2>/dev/null; then
+    break
+  fi
+done
+wait "$tee_pid"
+
+if [ "$status" -ne 0 ]; then
+  crash_line="$(grep -nE '^(panic|fatal error): ' "$stderr_log" | tail -n 1 | cut -d: -f1)"
+  if [ -n "$crash_line" ]; then
+    mkdir -p "$crash_dir"
+    tail -n "+$crash_line" "$stderr_log" > "$crash_dir/panic.log"
+    message="$(sed -n "${crash_line}p" "$stderr_log")"
+    goroutines="$(grep -cE '^goroutine [0-9]+ \[' "$crash_dir/panic.log")"
+    echo "CRASH: ${message} (exit status ${status}, ${goroutines} goroutines, trace saved to ${crash_dir}/panic.log)" >&2
+  fi
+fi
+
+exit "$status"
 `
-	return fmt.Sprintf(release, path.Base(mainPackageName))
 }
 
 func GoScript() string {
@@ -31,3 +169,105 @@ cd $GOPATH/src/%s
 `
 	return fmt.Sprintf(contents, path.Base(mainPackageName))
 }
+
+func MicroarchCheckScript(level string, features []string) string {
+	contents := `missing_cpu_features=""
+for feature in %s; do
+  if ! grep -qw "$feature" /proc/cpuinfo; then
+    missing_cpu_features="$missing_cpu_features $feature"
+  fi
+done
+if [ -n "$missing_cpu_features" ]; then
+  echo "ERROR: this app was built with %s, but this CPU lacks:$missing_cpu_features" >&2
+  exit 1
+fi
+`
+	return fmt.Sprintf(contents, strings.Join(features, " "), level)
+}
+
+func LibraryPathScript(libDirs []string) string {
+	contents := `export LD_LIBRARY_PATH=%s${LD_LIBRARY_PATH:+:$LD_LIBRARY_PATH}
+`
+	return fmt.Sprintf(contents, strings.Join(libDirs, ":"))
+}
+
+// GoCoverScript points coverage-instrumented binaries at a writable
+// GOCOVERDIR. Binaries write their counters there when they exit; collect
+// them with, for example:
+//
+//	cf ssh <app> -c 'tar -C "$GOCOVERDIR" -cz .' > covdata.tgz
+//
+// and report on them with 'go tool covdata percent -i=<dir>'.
+func GoCoverScript(coverDir string) string {
+	contents := `export GOCOVERDIR=${GOCOVERDIR:-%s}
+mkdir -p "$GOCOVERDIR"
+`
+	return fmt.Sprintf(contents, coverDir)
+}
+
+// GoRuntimeScript sizes the Go runtime to the container at launch. GOMEMLIMIT
+// is the container memory limit (MEMORY_LIMIT, else the cgroup limit) less
+// headroomPercent, and GOMAXPROCS is the CPU quota rounded up. Values the app
+// sets itself always win, so GOMEMLIMIT=off disables the memory limit.
+func GoRuntimeScript(headroomPercent int, defaults map[string]string) string {
+	contents := `if [ -z "${GOMEMLIMIT:-}" ]; then
+  go_limit_value=""
+  go_limit_unit=1
+  case "${MEMORY_LIMIT:-}" in
+    *[gG]) go_limit_value=${MEMORY_LIMIT%%?}; go_limit_unit=1073741824 ;;
+    *[mM]) go_limit_value=${MEMORY_LIMIT%%?}; go_limit_unit=1048576 ;;
+    *[kK]) go_limit_value=${MEMORY_LIMIT%%?}; go_limit_unit=1024 ;;
+    *) go_limit_value=${MEMORY_LIMIT:-} ;;
+  esac
+  if [ -z "$go_limit_value" ]; then
+    for go_limit_file in /sys/fs/cgroup/memory.max /sys/fs/cgroup/memory/memory.limit_in_bytes; do
+      if [ -r "$go_limit_file" ]; then
+        go_limit_value=$(cat "$go_limit_file")
+        break
+      fi
+    done
+  fi
+  case "$go_limit_value" in
+    ''|*[!0-9]*) ;;
+    *)
+      if [ ${#go_limit_value} -lt 19 ]; then
+        export GOMEMLIMIT=$(( go_limit_value * go_limit_unit / 100 * (100 - %d) ))
+      fi
+      ;;
+  esac
+  unset go_limit_value go_limit_unit go_limit_file
+fi
+if [ -z "${GOMAXPROCS:-}" ]; then
+  go_cpu_quota=""
+  go_cpu_period=""
+  if [ -r /sys/fs/cgroup/cpu.max ]; then
+    read -r go_cpu_quota go_cpu_period < /sys/fs/cgroup/cpu.max
+  elif [ -r /sys/fs/cgroup/cpu/cpu.cfs_quota_us ] && [ -r /sys/fs/cgroup/cpu/cpu.cfs_period_us ]; then
+    go_cpu_quota=$(cat /sys/fs/cgroup/cpu/cpu.cfs_quota_us)
+    go_cpu_period=$(cat /sys/fs/cgroup/cpu/cpu.cfs_period_us)
+  fi
+  case "$go_cpu_quota:$go_cpu_period" in
+    :*|*:|*[!0-9:]*|*:0) ;;
+    *) export GOMAXPROCS=$(( (go_cpu_quota + go_cpu_period - 1) / go_cpu_period )) ;;
+  esac
+  unset go_cpu_quota go_cpu_period
+fi
+`
+	script := fmt.Sprintf(contents, headroomPercent)
+
+	var names []string
+	for name := range defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		script += fmt.Sprintf("if [ -z \"${%s:-}\" ]; then\n  export %s=%s\nfi\n", name, name, shellQuote(defaults[name]))
+	}
+
+	return script
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,227 @@
+package finalize
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+type CgoConfig struct {
+	CFlags  string `yaml:"cflags"`
+	LDFlags string `yaml:"ldflags"`
+}
+
+// CheckCgo applies the cgo flags from buildpack.yml and, when any package
+// being installed uses cgo, makes sure the C compiler and the pkg-config
+// packages those packages ask for are available on the stack. Everything
+// that is missing is reported at once, before CompileApp runs.
+func (gf *Finalizer) CheckCgo(config BuildpackConfig) error {
+	if err := appendCgoFlags("CGO_CFLAGS", config.Cgo.CFlags); err != nil {
+		return err
+	}
+	if err := appendCgoFlags("CGO_LDFLAGS", config.Cgo.LDFlags); err != nil {
+		return err
+	}
+
+	if os.Getenv("CGO_ENABLED") == "0" {
+		return nil
+	}
+
+	cgoPackages, pkgConfigs, err := gf.listCgoPackages()
+	if err != nil {
+		return err
+	}
+
+	if len(cgoPackages) == 0 {
+		return nil
+	}
+
+	gf.Log.BeginStep("Checking cgo requirements for %s", strings.Join(cgoPackages, ", "))
+
+	var missing []string
+
+	cc, err := gf.goEnv("CC")
+	if err != nil {
+		return err
+	}
+	if cc == "" {
+		cc = "gcc"
+	}
+	if err := gf.Command.Execute(gf.mainPackagePath(), new(bytes.Buffer), new(bytes.Buffer), cc, "--version"); err != nil {
+		missing = append(missing, "C compiler: "+cc)
+	}
+
+	if len(pkgConfigs) > 0 {
+		if err := gf.Command.Execute(gf.mainPackagePath(), new(bytes.Buffer), new(bytes.Buffer), "pkg-config", "--version"); err != nil {
+			missing = append(missing, "pkg-config")
+		} else {
+			for _, pkg := range pkgConfigs {
+				if err := gf.Command.Execute(gf.mainPackagePath(), new(bytes.Buffer), new(bytes.Buffer), "pkg-config", "--exists", pkg); err != nil {
+					missing = append(missing, "pkg-config package: "+pkg)
+				}
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		gf.Log.Error("The following cgo requirements are missing from the stack:")
+		for _, m := range missing {
+			gf.Log.Info("    - %s", m)
+		}
+		gf.Log.Info("Provide them with an earlier supply buildpack, or set CGO_ENABLED=0 if cgo is not needed.")
+		return errors.New("missing cgo requirements")
+	}
+
+	return nil
+}
+
+// listCgoPackages returns the packages in the build graph that use cgo and
+// the pkg-config packages they name in #cgo pkg-config directives. Standard
+// library packages such as net and runtime/cgo are left out: without a C
+// compiler the go tool builds them with cgo turned off. Like the build, the
+// listing goes through godep for apps that rely on the Godeps workspace.
+func (gf *Finalizer) listCgoPackages() ([]string, []string, error) {
+	args := []string{"list", "-deps"}
+	args = append(args, gf.tagFlags()...)
+	args = append(args, "-f", `{{if and .CgoFiles (not .Standard)}}{{.ImportPath}} {{join .CgoPkgConfig " "}}{{end}}`)
+	args = append(args, gf.PackageList...)
+
+	cmd, args := gf.goCommand(args)
+
+	buffer := new(bytes.Buffer)
+	errorBuffer := new(bytes.Buffer)
+	if err := gf.Command.Execute(gf.mainPackagePath(), buffer, errorBuffer, cmd, args...); err != nil {
+		gf.Log.Error("problem listing cgo packages: %s", errorBuffer)
+		return nil, nil, err
+	}
+
+	var cgoPackages []string
+	seen := map[string]bool{}
+	var pkgConfigs []string
+	for _, line := range strings.Split(buffer.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		cgoPackages = append(cgoPackages, fields[0])
+		for _, pkg := range fields[1:] {
+			if !seen[pkg] && !strings.HasPrefix(pkg, "-") {
+				seen[pkg] = true
+				pkgConfigs = append(pkgConfigs, pkg)
+			}
+		}
+	}
+	sort.Strings(pkgConfigs)
+
+	return cgoPackages, pkgConfigs, nil
+}
+
+func (gf *Finalizer) goEnv(name string) (string, error) {
+	buffer := new(bytes.Buffer)
+	errorBuffer := new(bytes.Buffer)
+	if err := gf.Command.Execute(gf.mainPackagePath(), buffer, errorBuffer, "go", "env", name); err != nil {
+		gf.Log.Error("problem reading go env %s: %s", name, errorBuffer)
+		return "", err
+	}
+	return strings.TrimSpace(buffer.String()), nil
+}
+
+// appendCgoFlags adds flags to a CGO_* variable. An unset variable starts
+// from the toolchain's default of "-O2 -g" so that default is not lost.
+func appendCgoFlags(name, flags string) error {
+	if flags == "" {
+		return nil
+	}
+
+	existing := os.Getenv(name)
+	if existing == "" {
+		existing = "-O2 -g"
+	}
+	return os.Setenv(name, existing+" "+flags)
+}
+
+// SetCgoDepsEnvironment points cgo at the headers, libraries and pkg-config
+// files that buildpacks earlier in a multi-buildpack push placed in their
+// deps dirs. Binaries get an $ORIGIN-relative rpath to those libraries, and
+// DepsLibDirs records them for LD_LIBRARY_PATH at launch.
+func (gf *Finalizer) SetCgoDepsEnvironment() error {
+	depsIdx, err := strconv.Atoi(gf.Stager.DepsIdx())
+	if err != nil {
+		return nil
+	}
+
+	entries, err := os.ReadDir(gf.Stager.DepsDir())
+	if err != nil {
+		return err
+	}
+
+	var cflags, ldflags, pkgConfigPaths []string
+	gf.DepsLibDirs = nil
+
+	for _, entry := range entries {
+		idx, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() || idx >= depsIdx {
+			continue
+		}
+		depDir := filepath.Join(gf.Stager.DepsDir(), entry.Name())
+
+		if exists, err := libbuildpack.FileExists(filepath.Join(depDir, "include")); err != nil {
+			return err
+		} else if exists {
+			cflags = append(cflags, "-I"+filepath.Join(depDir, "include"))
+		}
+
+		if exists, err := libbuildpack.FileExists(filepath.Join(depDir, "lib")); err != nil {
+			return err
+		} else if exists {
+			libDir := filepath.Join(depDir, "lib")
+			ldflags = append(ldflags, "-L"+libDir)
+
+			rel, err := filepath.Rel(filepath.Join(gf.Stager.BuildDir(), "bin"), libDir)
+			if err != nil {
+				return err
+			}
+			ldflags = append(ldflags, "-Wl,-rpath,$ORIGIN/"+filepath.ToSlash(rel))
+			gf.DepsLibDirs = append(gf.DepsLibDirs, filepath.Join("$DEPS_DIR", entry.Name(), "lib"))
+		}
+
+		for _, dir := range []string{filepath.Join("lib", "pkgconfig"), "pkgconfig"} {
+			if exists, err := libbuildpack.FileExists(filepath.Join(depDir, dir)); err != nil {
+				return err
+			} else if exists {
+				pkgConfigPaths = append(pkgConfigPaths, filepath.Join(depDir, dir))
+			}
+		}
+	}
+
+	if len(cflags) == 0 && len(ldflags) == 0 && len(pkgConfigPaths) == 0 {
+		return nil
+	}
+
+	gf.Log.BeginStep("Using native libraries from other buildpacks for cgo")
+
+	if err := appendCgoFlags("CGO_CFLAGS", strings.Join(cflags, " ")); err != nil {
+		return err
+	}
+	if err := appendCgoFlags("CGO_LDFLAGS", strings.Join(ldflags, " ")); err != nil {
+		return err
+	}
+
+	if len(pkgConfigPaths) > 0 {
+		if existing := os.Getenv("PKG_CONFIG_PATH"); existing != "" {
+			pkgConfigPaths = append(pkgConfigPaths, existing)
+		}
+		if err := os.Setenv("PKG_CONFIG_PATH", strings.Join(pkgConfigPaths, ":")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package finalize
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxFailureOutputLines bounds how much of a failing test's output is
+// repeated in the staging log summary.
+const maxFailureOutputLines = 10
+
+type testEvent struct {
+	Action     string `json:"Action"`
+	Package    string `json:"Package"`
+	ImportPath string `json:"ImportPath"`
+	Test       string `json:"Test"`
+	Output     string `json:"Output"`
+}
+
+type testSummary struct {
+	Passed   int
+	Failed   []string
+	Skipped  int
+	Output   map[string][]string
+	Packages []string
+}
+
+func (gf *Finalizer) RunVet(config BuildpackConfig) error {
+	if len(config.Vet) == 0 {
+		return nil
+	}
+
+	args := []string{"vet"}
+	args = append(args, gf.tagFlags()...)
+	args = append(args, config.Vet...)
+
+	return gf.runGo(args)
+}
+
+func (gf *Finalizer) RunTests(config BuildpackConfig) error {
+	if len(config.Test) == 0 {
+		return nil
+	}
+
+	args := []string{"test", "-json"}
+	args = append(args, gf.BuildFlags...)
+	args = append(args, config.Test...)
+
+	cmd, args := gf.goCommand(args)
+	gf.Log.BeginStep("Running: %s %s", cmd, strings.Join(args, " "))
+
+	buffer := new(bytes.Buffer)
+	errorBuffer := new(bytes.Buffer)
+	runErr := gf.Command.Execute(gf.mainPackagePath(), buffer, errorBuffer, cmd, args...)
+
+	summary := parseTestEvents(buffer.Bytes())
+	gf.Log.Info("%d passed, %d failed, %d skipped", summary.Passed, len(summary.Failed), summary.Skipped)
+
+	if runErr == nil && len(summary.Failed) == 0 && len(summary.Packages) == 0 {
+		return nil
+	}
+
+	for _, pkg := range summary.Packages {
+		gf.Log.Error("Package %s failed", pkg)
+		for _, line := range tail(summary.Output[pkg], maxFailureOutputLines) {
+			gf.Log.Info("    %s", line)
+		}
+	}
+
+	for _, name := range summary.Failed {
+		gf.Log.Error("Test %s failed", name)
+		for _, line := range tail(summary.Output[name], maxFailureOutputLines) {
+			gf.Log.Info("    %s", line)
+		}
+	}
+
+	if errorBuffer.Len() > 0 {
+		gf.Log.Info("%s", strings.TrimSpace(errorBuffer.String()))
+	}
+
+	if runErr != nil {
+		return runErr
+	}
+	return errors.New("go test failed")
+}
+
+// tagFlags returns the -tags build flag, which go vet and go list accept.
+func (gf *Finalizer) tagFlags() []string {
+	var flags []string
+	for i := 0; i < len(gf.BuildFlags)-1; i++ {
+		if gf.BuildFlags[i] == "-tags" {
+			flags = append(flags, gf.BuildFlags[i], gf.BuildFlags[i+1])
+		}
+	}
+	return flags
+}
+
+func parseTestEvents(output []byte) testSummary {
+	summary := testSummary{Output: map[string][]string{}}
+	failedPackages := map[string]bool{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event testEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+
+		key := event.Package
+		if event.Test != "" {
+			key = fmt.Sprintf("%s %s", event.Package, event.Test)
+		}
+
+		switch event.Action {
+		case "output", "build-output":
+			if event.ImportPath != "" {
+				key = strings.Fields(event.ImportPath)[0]
+			}
+			if line := strings.TrimRight(event.Output, "\n"); line != "" {
+				summary.Output[key] = append(summary.Output[key], line)
+			}
+		case "pass":
+			if event.Test != "" {
+				summary.Passed++
+			}
+		case "skip":
+			if event.Test != "" {
+				summary.Skipped++
+			}
+		case "fail":
+			if event.Test != "" {
+				summary.Failed = append(summary.Failed, key)
+			} else {
+				failedPackages[event.Package] = true
+			}
+		}
+	}
+
+	for _, name := range summary.Failed {
+		delete(failedPackages, strings.SplitN(name, " ", 2)[0])
+	}
+	for pkg := range failedPackages {
+		summary.Packages = append(summary.Packages, pkg)
+	}
+	sort.Strings(summary.Packages)
+
+	return summary
+}
+
+func tail(lines []string, n int) []string {
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
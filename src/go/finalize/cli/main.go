@@ -41,6 +41,11 @@ func main() {
 		os.Exit(11)
 	}
 
+	if gf.SensitiveFiles, err = finalize.LoadOperatorSensitiveFiles(buildpackDir); err != nil {
+		logger.Error("Unable to read the sensitive files policy from manifest.yml: %s", err)
+		os.Exit(11)
+	}
+
 	if err := finalize.Run(gf); err != nil {
 		os.Exit(12)
 	}
@@ -0,0 +1,69 @@
+package finalize
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+const defaultCoverDir = "$TMPDIR/gocoverdir"
+
+type CoverConfig struct {
+	// Packages are the main packages to build with -cover.
+	Packages []string `yaml:"packages"`
+	// CoverPkg are the -coverpkg patterns; by default only the main
+	// package itself is instrumented.
+	CoverPkg []string `yaml:"coverpkg"`
+	// Dir is the GOCOVERDIR the binaries write to at launch.
+	Dir string `yaml:"dir"`
+}
+
+func (gf *Finalizer) SetCoverage(config CoverConfig) error {
+	gf.CoverPackages = map[string]bool{}
+
+	if len(config.Packages) == 0 {
+		return nil
+	}
+
+	ver, err := semver.NewVersion(gf.GoVersion)
+	if err != nil {
+		return err
+	}
+	if ver.LessThan(semver.MustParse("1.20.0")) {
+		return fmt.Errorf("go version %s cannot build coverage-instrumented binaries", gf.GoVersion)
+	}
+
+	for _, pkg := range config.Packages {
+		matched := false
+		for _, installPkg := range gf.PackageList {
+			if filepath.Clean(installPkg) == filepath.Clean(pkg) {
+				gf.CoverPackages[installPkg] = true
+				matched = true
+			}
+		}
+		if !matched {
+			gf.Log.Warning("Package %s is listed for coverage, but is not being installed", pkg)
+		}
+	}
+
+	if len(gf.CoverPackages) == 0 {
+		return nil
+	}
+
+	gf.CoverPkg = config.CoverPkg
+	gf.CoverDir = config.Dir
+	if gf.CoverDir == "" {
+		gf.CoverDir = defaultCoverDir
+	}
+
+	gf.Log.BeginStep("Building with coverage instrumentation")
+	if len(gf.CoverPkg) > 0 {
+		gf.Log.Info("Instrumenting packages matching %s", strings.Join(gf.CoverPkg, ", "))
+	}
+	gf.Log.Info("Coverage data will be written to GOCOVERDIR=%s when the app exits", gf.CoverDir)
+	gf.Log.Info("Collect it with: cf ssh <app> -c 'tar -C \"$GOCOVERDIR\" -cz .' > covdata.tgz")
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package finalize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/go-buildpack/src/go/data"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+type CrashCaptureConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// InstallCrashCapture writes the crash capture wrapper into <build-dir>/bin
+// and switches the release command to start the app through it.
+func (gf *Finalizer) InstallCrashCapture(config CrashCaptureConfig) error {
+	if !config.Enabled {
+		return nil
+	}
+
+	wrapper := filepath.Join(gf.Stager.BuildDir(), "bin", data.CrashCaptureWrapperName)
+	if exists, err := libbuildpack.FileExists(wrapper); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("the crash capture wrapper would overwrite bin/%s", data.CrashCaptureWrapperName)
+	}
+
+	gf.Log.BeginStep("Installing the crash capture wrapper")
+
+	if err := os.WriteFile(wrapper, []byte(data.CrashCaptureScript()), 0755); err != nil {
+		return err
+	}
+
+	gf.CrashCapture = true
+	return nil
+}
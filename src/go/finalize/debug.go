@@ -0,0 +1,32 @@
+package finalize
+
+import (
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const defaultDebugPort = 40000
+
+type DebugConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Port    int  `yaml:"port"`
+}
+
+// InstallDelve copies the Delve binary installed by supply into
+// <build-dir>/bin, since the deps dir is cleared before the droplet is made,
+// and switches the release command to run the app under Delve.
+func (gf *Finalizer) InstallDelve(config DebugConfig) error {
+	if !config.Enabled {
+		return nil
+	}
+
+	gf.DebugPort = config.Port
+	if gf.DebugPort == 0 {
+		gf.DebugPort = defaultDebugPort
+	}
+
+	gf.Log.BeginStep("Installing Delve for the debug build profile (port %d)", gf.DebugPort)
+
+	return libbuildpack.CopyFile(filepath.Join(gf.Stager.DepDir(), "dlv", "bin", "dlv"), filepath.Join(gf.Stager.BuildDir(), "bin", "dlv"))
+}
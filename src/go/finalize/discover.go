@@ -0,0 +1,121 @@
+package finalize
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/go-buildpack/src/go/warnings"
+)
+
+// discoverMainPackages picks the packages to build for a module, or for a
+// GOPATH-layout app, when no package spec is given. A main package at the
+// module root is built on its own, as before, without looking at the rest of
+// the module. Otherwise a single main package is built, or all of them when
+// the web process names one of their binaries. Discovery lists packages with
+// -e, so a package that does not build, and is not going to be built, does
+// not stop it.
+func (gf *Finalizer) discoverMainPackages(webBinary string) ([]string, error) {
+	if gf.VendorTool != "gopath" {
+		root, err := gf.listMainPackages([]string{"-e"}, ".")
+		if err != nil {
+			return nil, err
+		}
+		if len(root) > 0 {
+			gf.Log.Warning("Installing package '.' (default)")
+			return []string{"."}, nil
+		}
+	}
+
+	candidates, err := gf.listMainPackages([]string{"-e"}, "./...")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(candidates)
+
+	scope := "the module"
+	if gf.VendorTool == "gopath" {
+		scope = "src/"
+		if len(candidates) == 0 {
+			return nil, errors.New("found no main packages under src/")
+		}
+	}
+
+	if len(candidates) == 0 {
+		gf.Log.Warning("Installing package '.' (default)")
+		return []string{"."}, nil
+	}
+
+	if len(candidates) == 1 {
+		gf.Log.BeginStep("Installing package %s, the only main package in %s", candidates[0], scope)
+		return candidates, nil
+	}
+
+	if webBinary == "" {
+		gf.Log.Error("%s", warnings.MultipleMainPackagesError(candidates))
+		return nil, fmt.Errorf("found %d main packages and no web process binary", len(candidates))
+	}
+
+	packages := []string{}
+	for _, pkg := range candidates {
+		if path.Base(pkg) == webBinary {
+			packages = append([]string{pkg}, packages...)
+		} else {
+			packages = append(packages, pkg)
+		}
+	}
+
+	if path.Base(packages[0]) != webBinary {
+		gf.Log.Error("%s", warnings.MultipleMainPackagesError(candidates))
+		return nil, fmt.Errorf("web process binary %s is not built by any main package", webBinary)
+	}
+
+	gf.Log.BeginStep("Installing all main packages in %s, with %s as web", scope, packages[0])
+	return packages, nil
+}
+
+// listMainPackages returns the main packages matching patterns, in the order
+// go list reports them, passing flags on to go list. Packages inside the
+// module are returned as ./ paths relative to its root, others by import path.
+func (gf *Finalizer) listMainPackages(flags []string, patterns ...string) ([]string, error) {
+	args := append([]string{"list"}, flags...)
+	args = append(args, gf.tagFlags()...)
+	args = append(args, "-f", `{{if eq .Name "main"}}{{.ImportPath}}{{"\t"}}{{.Dir}}{{end}}`)
+	args = append(args, patterns...)
+
+	cmd, args := gf.goCommand(args)
+
+	buffer := new(bytes.Buffer)
+	errorBuffer := new(bytes.Buffer)
+	if err := gf.Command.Execute(gf.mainPackagePath(), buffer, errorBuffer, cmd, args...); err != nil {
+		gf.Log.Error("problem listing main packages: %s", errorBuffer)
+		return nil, err
+	}
+
+	var packages []string
+	for _, line := range strings.Split(buffer.String(), "\n") {
+		importPath, dir, found := strings.Cut(line, "\t")
+		if !found {
+			continue
+		}
+
+		rel, err := filepath.Rel(gf.mainPackagePath(), dir)
+		if err != nil {
+			return nil, err
+		}
+
+		if rel == "." {
+			packages = append(packages, ".")
+		} else if rel == ".." || strings.HasPrefix(rel, "../") {
+			packages = append(packages, importPath)
+		} else {
+			packages = append(packages, "./"+filepath.ToSlash(rel))
+		}
+	}
+
+	return packages, nil
+}
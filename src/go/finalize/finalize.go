@@ -6,11 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/Masterminds/semver"
+	"github.com/cloudfoundry/go-buildpack/src/go/buildpackyml"
 	"github.com/cloudfoundry/go-buildpack/src/go/data"
 	"github.com/cloudfoundry/go-buildpack/src/go/godep"
 	"github.com/cloudfoundry/go-buildpack/src/go/warnings"
@@ -22,7 +26,78 @@ type Command interface {
 }
 
 type BuildpackConfig struct {
-	LDFlags map[string]string `yaml:"ldflags"`
+	LDFlags        map[string]string        `yaml:"ldflags"`
+	Vet            []string                 `yaml:"vet"`
+	Test           []string                 `yaml:"test"`
+	PGO            map[string]string        `yaml:"pgo"`
+	Reproducible   bool                     `yaml:"reproducible"`
+	Strip          StripConfig              `yaml:"strip"`
+	GOAMD64        string                   `yaml:"goamd64"`
+	GOARM64        string                   `yaml:"goarm64"`
+	Cgo            CgoConfig                `yaml:"cgo"`
+	Prune          PruneConfig              `yaml:"prune"`
+	SensitiveFiles SensitiveFilesConfig     `yaml:"sensitive_files"`
+	Debug          DebugConfig              `yaml:"debug"`
+	Cover          CoverConfig              `yaml:"cover"`
+	Plugins        []PluginConfig           `yaml:"plugins"`
+	Tools          []string                 `yaml:"tools"`
+	Tags           []string                 `yaml:"tags"`
+	Runtime        RuntimeConfig            `yaml:"runtime"`
+	CrashCapture   CrashCaptureConfig       `yaml:"crash_capture"`
+	Processes      map[string]ProcessConfig `yaml:"processes"`
+	Packages       []string                 `yaml:"packages"`
+}
+
+func (c BuildpackConfig) Validate() error {
+	if err := c.Strip.Validate(); err != nil {
+		return fmt.Errorf("strip: %s", err)
+	}
+
+	if err := c.Prune.Validate(); err != nil {
+		return fmt.Errorf("prune: %s", err)
+	}
+
+	if err := c.Runtime.Validate(); err != nil {
+		return fmt.Errorf("runtime: %s", err)
+	}
+
+	for name, process := range c.Processes {
+		if !processTypeName.MatchString(name) {
+			return fmt.Errorf("processes: %q is not a valid process type", name)
+		}
+		if err := process.Validate(); err != nil {
+			return fmt.Errorf("processes: %s: %s", name, err)
+		}
+	}
+
+	for _, plugin := range c.Plugins {
+		if err := plugin.Validate(); err != nil {
+			return fmt.Errorf("plugins: %s", err)
+		}
+	}
+
+	// A plugin only loads into a host whose shared packages were compiled
+	// identically, and plugins are built without the host's -pgo and -cover
+	// flags.
+	if len(c.Plugins) > 0 && (len(c.PGO) > 0 || len(c.Cover.Packages) > 0) {
+		return errors.New("plugins: cannot be combined with pgo or cover builds")
+	}
+
+	for _, tool := range c.Tools {
+		if err := validateTool(tool); err != nil {
+			return fmt.Errorf("tools: %s", err)
+		}
+	}
+
+	if c.Debug.Enabled && c.Strip.Mode != "" {
+		return errors.New("debug builds cannot be stripped")
+	}
+
+	if c.Debug.Enabled && c.CrashCapture.Enabled {
+		return errors.New("debug builds cannot use crash capture")
+	}
+
+	return nil
 }
 
 type Stager interface {
@@ -30,22 +105,38 @@ type Stager interface {
 	CacheDir() string
 	ClearDepDir() error
 	DepDir() string
+	DepsDir() string
 	DepsIdx() string
 	WriteProfileD(string, string) error
 }
 
 type Finalizer struct {
-	Stager           Stager
-	Command          Command
-	Log              *libbuildpack.Logger
-	VendorTool       string
-	GoVersion        string
-	Godep            godep.Godep
-	MainPackageName  string
-	GoPath           string
-	PackageList      []string
-	BuildFlags       []string
-	VendorExperiment bool
+	Stager              Stager
+	Command             Command
+	Log                 *libbuildpack.Logger
+	VendorTool          string
+	GoVersion           string
+	Godep               godep.Godep
+	MainPackageName     string
+	GoPath              string
+	PackageList         []string
+	BuildFlags          []string
+	VendorExperiment    bool
+	PGOProfiles         map[string]string
+	MicroarchLevel      string
+	RequiredCPUFeatures []string
+	DepsLibDirs         []string
+	DebugPort           int
+	CoverPackages       map[string]bool
+	CoverPkg            []string
+	CoverDir            string
+	PluginOutputs       []string
+	MemoryHeadroom      int
+	RuntimeDefaults     map[string]string
+	CrashCapture        bool
+	Processes           map[string]ProcessConfig
+	ModuleDir           string
+	SensitiveFiles      SensitiveFilesConfig
 }
 
 func NewFinalizer(stager Stager, command Command, logger *libbuildpack.Logger) (*Finalizer, error) {
@@ -54,6 +145,7 @@ func NewFinalizer(stager Stager, command Command, logger *libbuildpack.Logger) (
 			GoVersion  string `yaml:"GoVersion"`
 			VendorTool string `yaml:"VendorTool"`
 			Godep      string `yaml:"Godep"`
+			ModuleDir  string `yaml:"ModuleDir"`
 		} `yaml:"config"`
 	}{}
 	if err := libbuildpack.NewYAML().Load(filepath.Join(stager.DepDir(), "config.yml"), &config); err != nil {
@@ -76,6 +168,7 @@ func NewFinalizer(stager Stager, command Command, logger *libbuildpack.Logger) (
 		Godep:      godep,
 		GoVersion:  config.Config.GoVersion,
 		VendorTool: config.Config.VendorTool,
+		ModuleDir:  config.Config.ModuleDir,
 	}, nil
 }
 
@@ -85,18 +178,20 @@ func Run(gf *Finalizer) error {
 	}
 	config.Go.LDFlags = map[string]string{}
 
-	buildpackYAMLPath := filepath.Join(gf.Stager.BuildDir(), "buildpack.yml")
-	ok, err := libbuildpack.FileExists(buildpackYAMLPath)
+	profile, resolved, err := buildpackyml.Load(gf.Stager.BuildDir(), &config)
 	if err != nil {
-		gf.Log.Error("Unable to stat buildpack.yml: %s", err)
+		gf.Log.Error("Unable to parse buildpack.yml: %s", err)
 		return err
 	}
 
-	if ok {
-		if err := libbuildpack.NewYAML().Load(buildpackYAMLPath, &config); err != nil {
-			gf.Log.Error("Unable to parse buildpack.yml: %s", err)
-			return err
-		}
+	if profile != "" {
+		gf.Log.BeginStep("Using build profile %s", profile)
+		gf.Log.Info("%s", strings.TrimSpace(resolved))
+	}
+
+	if err := config.Go.Validate(); err != nil {
+		gf.Log.Error("Invalid buildpack.yml: %s", err)
+		return err
 	}
 
 	if err := gf.SetGoCache(); err != nil {
@@ -143,19 +238,103 @@ func Run(gf *Finalizer) error {
 		}
 	}
 
+	if err := gf.SetMicroarchitecture(config.Go); err != nil {
+		gf.Log.Error("Unable to select the CPU microarchitecture level: %s", err)
+		return err
+	}
+
 	gf.SetBuildFlags(config.Go)
 
-	if err := gf.SetInstallPackages(); err != nil {
+	if err := gf.SetInstallPackages(config.Go); err != nil {
 		gf.Log.Error("Unable to determine packages to install: %s", err)
 		return err
 	}
 
+	if err := gf.SetCgoDepsEnvironment(); err != nil {
+		gf.Log.Error("Unable to use native libraries from other buildpacks: %s", err)
+		return err
+	}
+
+	if err := gf.CheckCgo(config.Go); err != nil {
+		gf.Log.Error("Unable to build with cgo: %s", err)
+		return err
+	}
+
+	if err := gf.SetPGOProfiles(config.Go); err != nil {
+		gf.Log.Error("Unable to use profile-guided optimization: %s", err)
+		return err
+	}
+
+	if err := gf.SetCoverage(config.Go.Cover); err != nil {
+		gf.Log.Error("Unable to build with coverage instrumentation: %s", err)
+		return err
+	}
+
+	if err := gf.RunVet(config.Go); err != nil {
+		gf.Log.Error("Error running 'go vet': %s", err)
+		return err
+	}
+
+	if err := gf.RunTests(config.Go); err != nil {
+		gf.Log.Error("Error running 'go test': %s", err)
+		return err
+	}
+
 	if err := gf.CompileApp(); err != nil {
 		gf.Log.Error("Unable to compile application: %s", err)
 		return err
 	}
 
-	if err := gf.CreateStartupEnvironment("/tmp"); err != nil {
+	if err := gf.InstallTools(config.Go.Tools); err != nil {
+		gf.Log.Error("Unable to install tools: %s", err)
+		return err
+	}
+
+	if err := gf.BuildPlugins(config.Go.Plugins); err != nil {
+		gf.Log.Error("Unable to build plugins: %s", err)
+		return err
+	}
+
+	if err := gf.StripBinaries(config.Go.Strip); err != nil {
+		gf.Log.Error("Unable to strip binaries: %s", err)
+		return err
+	}
+
+	if config.Go.Reproducible {
+		if err := gf.LogBinaryChecksums(); err != nil {
+			gf.Log.Error("Unable to checksum binaries: %s", err)
+			return err
+		}
+	}
+
+	if err := gf.InstallDelve(config.Go.Debug); err != nil {
+		gf.Log.Error("Unable to install Delve: %s", err)
+		return err
+	}
+
+	if err := gf.InstallCrashCapture(config.Go.CrashCapture); err != nil {
+		gf.Log.Error("Unable to install the crash capture wrapper: %s", err)
+		return err
+	}
+
+	if err := gf.SetProcesses(config.Go.Processes); err != nil {
+		gf.Log.Error("Unable to set process types: %s", err)
+		return err
+	}
+
+	if err := gf.PruneBuildDir(config.Go.Prune); err != nil {
+		gf.Log.Error("Unable to prune the build directory: %s", err)
+		return err
+	}
+
+	if err := gf.CheckSensitiveFiles(config.Go.SensitiveFiles); err != nil {
+		gf.Log.Error("Sensitive file check failed: %s", err)
+		return err
+	}
+
+	gf.SetRuntimeTuning(config.Go.Runtime)
+
+	if err := gf.CreateStartupEnvironment(); err != nil {
 		gf.Log.Error("Unable to create startup scripts: %s", err)
 		return err
 	}
@@ -181,19 +360,34 @@ func (gf *Finalizer) SetMainPackageName() error {
 		fallthrough
 	case "go_nativevendoring":
 		gf.MainPackageName = os.Getenv("GOPACKAGENAME")
-		if gf.MainPackageName == "" {
+		if gf.MainPackageName != "" {
+			return nil
+		}
+
+		importPath, source, err := gf.inferImportPath()
+		if err != nil {
+			return err
+		}
+		if importPath == "" {
 			gf.Log.Error("%s", warnings.NoGOPACKAGENAMEerror())
 			return errors.New("GOPACKAGENAME unset")
 		}
+
+		gf.Log.BeginStep("Using import path %s from %s", importPath, source)
+		gf.MainPackageName = importPath
 	case "gomod":
 		buffer := new(bytes.Buffer)
 		errorBuffer := new(bytes.Buffer)
 
-		if err := gf.Command.Execute(gf.Stager.BuildDir(), buffer, errorBuffer, "go", "list", "-m"); err != nil {
+		if err := gf.Command.Execute(gf.mainPackagePath(), buffer, errorBuffer, "go", "list", "-m"); err != nil {
 			gf.Log.Error("problem retrieving main package name: %s", errorBuffer)
 			return err
 		}
 		gf.MainPackageName = strings.TrimSpace(buffer.String())
+	case "gopath":
+		// The app is a GOPATH of its own, whose packages are named by
+		// their directories under src/.
+		gf.MainPackageName = ""
 	default:
 		return errors.New("invalid vendor tool")
 	}
@@ -205,6 +399,10 @@ func (gf *Finalizer) SetGoCache() error {
 }
 
 func (gf *Finalizer) SetupGoPath() error {
+	if gf.VendorTool == "gopath" {
+		return gf.useBuildDirAsGoPath()
+	}
+
 	var skipMoveFile = map[string]bool{
 		".cloudfoundry": true,
 		"Procfile":      true,
@@ -266,27 +464,147 @@ func (gf *Finalizer) SetupGoPath() error {
 			return err
 		}
 
-		err = libbuildpack.CopyDirectory(gf.Stager.BuildDir(), packageDir)
+		copied, err := linkDirectory(gf.Stager.BuildDir(), packageDir)
 		if err != nil {
 			return err
 		}
+		if copied > 0 {
+			gf.Log.Info("Copied %d files into GOPATH that could not be hard linked", copied)
+		}
 	}
 	// unset git dir or it will mess with go install
 	return os.Unsetenv("GIT_DIR")
 }
 
+// linkDirectory recreates the directories of srcDir under destDir and hard
+// links its files into them, so the temporary GOPATH costs no copying. The
+// go tool sees real directories, so ./... patterns and vendor/ lookups work
+// as they did on a copy. Files that cannot be linked, such as those on
+// another filesystem, are copied; linkDirectory returns how many were.
+func linkDirectory(srcDir, destDir string) (int, error) {
+	copied := 0
+
+	err := filepath.WalkDir(srcDir, func(src string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(srcDir, src)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(destDir, rel)
+
+		switch {
+		case entry.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dest)
+		case entry.IsDir():
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			return os.MkdirAll(dest, info.Mode().Perm())
+		}
+
+		if err := os.Link(src, dest); err != nil {
+			copied++
+			return libbuildpack.CopyFile(src, dest)
+		}
+		return nil
+	})
+
+	return copied, err
+}
+
+// useBuildDirAsGoPath builds a GOPATH-layout app where it is. A gb-style
+// vendor/src joins the GOPATH after the app, and src/vendor is picked up by
+// the go tool on its own.
+func (gf *Finalizer) useBuildDirAsGoPath() error {
+	buildDir := gf.Stager.BuildDir()
+	gf.GoPath = buildDir
+
+	goPath := []string{buildDir}
+	gf.Log.BeginStep("Using the app as a GOPATH, with packages under src/")
+
+	if exists, err := libbuildpack.FileExists(filepath.Join(buildDir, "vendor", "src")); err != nil {
+		return err
+	} else if exists {
+		gf.Log.Info("Adding vendor/ to GOPATH")
+		goPath = append(goPath, filepath.Join(buildDir, "vendor"))
+	}
+
+	if exists, err := libbuildpack.FileExists(filepath.Join(buildDir, "src", "vendor")); err != nil {
+		return err
+	} else if exists {
+		gf.Log.Info("Using vendored packages from src/vendor")
+	}
+
+	if err := os.Setenv("GOPATH", strings.Join(goPath, string(filepath.ListSeparator))); err != nil {
+		return err
+	}
+
+	binDir := filepath.Join(buildDir, "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return err
+	}
+
+	if err := os.Setenv("GOBIN", binDir); err != nil {
+		return err
+	}
+
+	return os.Unsetenv("GIT_DIR")
+}
+
 func (gf *Finalizer) SetBuildFlags(config BuildpackConfig) {
-	flags := []string{"-tags", "cloudfoundry", "-buildmode", "pie"}
+	tags := append([]string{"cloudfoundry"}, config.Tags...)
+	flags := []string{"-tags", strings.Join(tags, ","), "-buildmode", "pie"}
+
+	if config.Reproducible {
+		flags = append(flags, "-trimpath")
+	}
+
+	if config.Debug.Enabled {
+		flags = append(flags, "-gcflags", "all=-N -l")
+	}
+
+	// From Go 1.21 the host is built with its main package's default.pgo.
+	// A plugin has no main package to take a profile from, and a plugin whose
+	// shared packages were optimized differently from the host's does not
+	// load, so profile-guided optimization is turned off for both.
+	if len(config.Plugins) > 0 {
+		if ver, err := semver.NewVersion(gf.GoVersion); err == nil && !ver.LessThan(semver.MustParse("1.21.0")) {
+			flags = append(flags, "-pgo", "off")
+		}
+	}
 
 	if os.Getenv("GO_LINKER_SYMBOL") != "" && os.Getenv("GO_LINKER_VALUE") != "" {
 		config.LDFlags[os.Getenv("GO_LINKER_SYMBOL")] = os.Getenv("GO_LINKER_VALUE")
 	}
 
-	if len(config.LDFlags) > 0 {
-		var ldflags []string
-		for key, val := range config.LDFlags {
-			ldflags = append(ldflags, fmt.Sprintf("-X %s=%s", key, val))
-		}
+	var keys []string
+	for key := range config.LDFlags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var ldflags []string
+	for _, key := range keys {
+		ldflags = append(ldflags, fmt.Sprintf("-X %s=%s", key, gf.expandLDFlagValue(config.LDFlags[key], config.Reproducible)))
+	}
+
+	if config.Strip.Mode == "linker" {
+		ldflags = append(ldflags, "-s", "-w")
+	}
+
+	if config.Reproducible {
+		ldflags = append(ldflags, "-buildid=")
+	}
+
+	if len(ldflags) > 0 {
 		flags = append(flags, "-ldflags", strings.Join(ldflags, " "))
 	}
 
@@ -397,11 +715,23 @@ func (gf *Finalizer) HandleVendorExperiment() error {
 	return nil
 }
 
-func (gf *Finalizer) SetInstallPackages() error {
+func (gf *Finalizer) SetInstallPackages(config BuildpackConfig) error {
 	var packages []string
+	var err error
+
+	specFromEnv := os.Getenv("GO_INSTALL_PACKAGE_SPEC") != ""
+	if specFromEnv {
+		if packages, err = parsePackageSpec(os.Getenv("GO_INSTALL_PACKAGE_SPEC")); err != nil {
+			return err
+		}
+	} else {
+		packages = config.Packages
+	}
 
-	if os.Getenv("GO_INSTALL_PACKAGE_SPEC") != "" {
-		packages = append(packages, strings.Split(os.Getenv("GO_INSTALL_PACKAGE_SPEC"), " ")...)
+	if len(packages) != 0 {
+		if packages, err = gf.resolvePackageSpec(packages); err != nil {
+			return err
+		}
 	}
 
 	vendorDirExists, err := libbuildpack.FileExists(filepath.Join(gf.mainPackagePath(), "vendor"))
@@ -420,11 +750,11 @@ func (gf *Finalizer) SetInstallPackages() error {
 			gf.Log.Warning("vendor/ directory does not exist.")
 		}
 
-		if len(packages) != 0 {
+		if specFromEnv {
 			gf.Log.Warning("%s", warnings.PackageSpecOverride(packages))
-		} else if len(gf.Godep.Packages) != 0 {
+		} else if len(packages) == 0 && len(gf.Godep.Packages) != 0 {
 			packages = gf.Godep.Packages
-		} else {
+		} else if len(packages) == 0 {
 			gf.Log.Warning("Installing package '.' (default)")
 			packages = append(packages, ".")
 		}
@@ -438,7 +768,11 @@ func (gf *Finalizer) SetInstallPackages() error {
 			return errors.New("must use vendor/ for go native vendoring")
 		}
 
-		if len(packages) == 0 {
+		if len(packages) == 0 && (gf.VendorTool == "gomod" || gf.VendorTool == "gopath") {
+			if packages, err = gf.discoverMainPackages(config.Processes["web"].Binary); err != nil {
+				return err
+			}
+		} else if len(packages) == 0 {
 			packages = append(packages, ".")
 			gf.Log.Warning("Installing package '.' (default)")
 		}
@@ -446,40 +780,114 @@ func (gf *Finalizer) SetInstallPackages() error {
 		packages = gf.updatePackagesForVendor(packages)
 	}
 
+	if specFromEnv || len(config.Packages) != 0 {
+		gf.Log.BeginStep("Installing packages from the package spec:")
+		for _, pkg := range packages {
+			gf.Log.Info("%s", pkg)
+		}
+	}
+
 	gf.PackageList = packages
 	return nil
 }
 
 func (gf *Finalizer) CompileApp() error {
-	cmd := "go"
+	var packages []string
+	for _, pkg := range gf.PackageList {
+		if len(gf.packageFlags(pkg)) == 0 {
+			packages = append(packages, pkg)
+		}
+	}
+
+	if len(packages) > 0 {
+		if err := gf.goInstall(packages); err != nil {
+			return err
+		}
+	}
+
+	for _, pkg := range gf.PackageList {
+		flags := gf.packageFlags(pkg)
+		if len(flags) == 0 {
+			continue
+		}
+
+		if err := gf.goInstall([]string{pkg}, flags...); err != nil {
+			return err
+		}
+
+		if profile, ok := gf.PGOProfiles[pkg]; ok {
+			gf.Log.Info("Built %s with profile-guided optimization (%s)", gf.binaryName(pkg), profile)
+		}
+		if gf.CoverPackages[pkg] {
+			gf.Log.Info("Built %s with coverage instrumentation", gf.binaryName(pkg))
+		}
+	}
+
+	return nil
+}
+
+// packageFlags returns the build flags that apply only to pkg, which then
+// has to be installed on its own.
+func (gf *Finalizer) packageFlags(pkg string) []string {
+	var flags []string
+
+	if profile, ok := gf.PGOProfiles[pkg]; ok {
+		flags = append(flags, "-pgo", profile)
+	}
+
+	if gf.CoverPackages[pkg] {
+		flags = append(flags, "-cover")
+		if len(gf.CoverPkg) > 0 {
+			flags = append(flags, "-coverpkg", strings.Join(gf.CoverPkg, ","))
+		}
+	}
+
+	return flags
+}
+
+func (gf *Finalizer) goInstall(packages []string, extraFlags ...string) error {
 	args := []string{"install"}
 	args = append(args, gf.BuildFlags...)
-	args = append(args, gf.PackageList...)
+	args = append(args, extraFlags...)
+	args = append(args, packages...)
 
-	if gf.VendorTool == "godep" && (gf.Godep.WorkspaceExists || !gf.VendorExperiment) {
-		args = append([]string{"go"}, args...)
-		cmd = "godep"
-	}
+	return gf.runGo(args)
+}
+
+// runGo runs a go build command, wrapped with godep when the app relies on
+// the Godeps workspace.
+func (gf *Finalizer) runGo(args []string) error {
+	cmd, args := gf.goCommand(args)
 
 	gf.Log.BeginStep("Running: %s %s", cmd, strings.Join(args, " "))
 
-	err := gf.Command.Execute(gf.mainPackagePath(), os.Stdout, os.Stderr, cmd, args...)
-	if err != nil {
-		return err
+	return gf.Command.Execute(gf.mainPackagePath(), os.Stdout, os.Stderr, cmd, args...)
+}
+
+// goCommand returns the command and arguments that run go with args, so
+// that everything that resolves the app's imports sees the same packages as
+// the build: apps relying on the Godeps workspace go through godep.
+func (gf *Finalizer) goCommand(args []string) (string, []string) {
+	if gf.VendorTool == "godep" && (gf.Godep.WorkspaceExists || !gf.VendorExperiment) {
+		return "godep", append([]string{"go"}, args...)
 	}
-	return nil
+	return "go", args
 }
 
-func (gf *Finalizer) CreateStartupEnvironment(tempDir string) error {
+func (gf *Finalizer) CreateStartupEnvironment() error {
 	mainPkgName := gf.MainPackageName
-	if len(gf.PackageList) > 0 && gf.PackageList[0] != "." {
+	if len(gf.PackageList) > 0 && gf.PackageList[0] != "." && gf.VendorTool != "gopath" {
 		mainPkgName = filepath.Base(gf.PackageList[0])
 	}
 
-	err := os.WriteFile(filepath.Join(tempDir, "buildpack-release-step.yml"), []byte(data.ReleaseYAML(mainPkgName)), 0644)
-	if err != nil {
-		gf.Log.Error("Unable to write release yml: %s", err)
-		return err
+	processes := gf.Processes
+	if len(processes) == 0 {
+		processes = map[string]ProcessConfig{"web": {Binary: gf.mainBinaryName()}}
+	}
+
+	commands := map[string]string{}
+	for name, process := range processes {
+		commands[name] = gf.startCommand(name, process)
 	}
 
 	if os.Getenv("GO_INSTALL_TOOLS_IN_IMAGE") == "true" {
@@ -493,6 +901,12 @@ func (gf *Finalizer) CreateStartupEnvironment(tempDir string) error {
 		}
 	}
 
+	// bin/release reads the start commands from the deps dir of this staging.
+	if err := os.WriteFile(filepath.Join(gf.Stager.DepDir(), "buildpack-release-step.yml"), []byte(data.ReleaseYAML(commands)), 0644); err != nil {
+		gf.Log.Error("Unable to write release yml: %s", err)
+		return err
+	}
+
 	if os.Getenv("GO_SETUP_GOPATH_IN_IMAGE") == "true" {
 		gf.Log.BeginStep("Cleaning up $GOPATH/pkg")
 		if err := os.RemoveAll(filepath.Join(gf.GoPath, "pkg")); err != nil {
@@ -504,16 +918,45 @@ func (gf *Finalizer) CreateStartupEnvironment(tempDir string) error {
 		}
 	}
 
+	if len(gf.DepsLibDirs) > 0 {
+		if err := gf.Stager.WriteProfileD("godepslibs.sh", data.LibraryPathScript(gf.DepsLibDirs)); err != nil {
+			return err
+		}
+	}
+
+	if len(gf.CoverPackages) > 0 {
+		if err := gf.Stager.WriteProfileD("gocover.sh", data.GoCoverScript(gf.CoverDir)); err != nil {
+			return err
+		}
+	}
+
+	if len(gf.RequiredCPUFeatures) > 0 {
+		if err := gf.Stager.WriteProfileD("gomicroarch.sh", data.MicroarchCheckScript(gf.MicroarchLevel, gf.RequiredCPUFeatures)); err != nil {
+			return err
+		}
+	}
+
+	if err := gf.Stager.WriteProfileD("goruntime.sh", data.GoRuntimeScript(gf.MemoryHeadroom, gf.RuntimeDefaults)); err != nil {
+		return err
+	}
+
 	return gf.Stager.WriteProfileD("go.sh", data.GoScript())
 }
 
 func (gf *Finalizer) mainPackagePath() string {
 	if gf.VendorTool == "gomod" {
-		return gf.Stager.BuildDir()
+		return filepath.Join(gf.Stager.BuildDir(), gf.ModuleDir)
 	}
 	return filepath.Join(gf.GoPath, "src", gf.MainPackageName)
 }
 
+func (gf *Finalizer) binaryName(pkg string) string {
+	if pkg == "." {
+		return path.Base(gf.MainPackageName)
+	}
+	return path.Base(filepath.ToSlash(pkg))
+}
+
 func (gf *Finalizer) goInstallLocation() string {
 	return filepath.Join(gf.Stager.DepDir(), "go"+gf.GoVersion)
 }
@@ -2,8 +2,10 @@ package finalize_test
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 
@@ -11,6 +13,7 @@ import (
 	"github.com/cloudfoundry/go-buildpack/src/go/godep"
 	"github.com/cloudfoundry/libbuildpack"
 	"github.com/cloudfoundry/libbuildpack/ansicleaner"
+	"github.com/google/pprof/profile"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
@@ -39,6 +42,7 @@ var _ = Describe("Finalize", func() {
 		buildFlags       []string
 		godepConfig      godep.Godep
 		vendorExperiment bool
+		moduleDir        string
 	)
 
 	BeforeEach(func() {
@@ -77,6 +81,7 @@ var _ = Describe("Finalize", func() {
 			BuildFlags:       buildFlags,
 			Godep:            godepConfig,
 			VendorExperiment: vendorExperiment,
+			ModuleDir:        moduleDir,
 		}
 	})
 
@@ -134,6 +139,24 @@ config:
 				Expect(finalizer.VendorTool).To(Equal("dep"))
 			})
 		})
+		Context("the module is in a subdirectory", func() {
+			BeforeEach(func() {
+				os.WriteFile(filepath.Join(depsDir, depsIdx, "config.yml"), []byte(`name: "go"
+config:
+  GoVersion: 1.22.5
+  VendorTool: gomod
+  ModuleDir: services/api
+`), 0644)
+			})
+
+			It("initializes values from config.yml", func() {
+				finalizer, err := finalize.NewFinalizer(stager, mockCommand, logger)
+				Expect(err).To(BeNil())
+
+				Expect(finalizer.VendorTool).To(Equal("gomod"))
+				Expect(finalizer.ModuleDir).To(Equal("services/api"))
+			})
+		})
 	})
 
 	Describe("SetMainPackageName", func() {
@@ -151,6 +174,214 @@ config:
 			})
 		})
 
+		Context("the vendor tool is gomod and GO_INSTALL_PACKAGE_SPEC is not set", func() {
+			var (
+				rootIsMain bool
+				mainDirs   []string
+				listErrors string
+			)
+
+			BeforeEach(func() {
+				vendorTool = "gomod"
+				buildFlags = []string{"-tags", "cloudfoundry", "-buildmode", "pie"}
+				rootIsMain = false
+				listErrors = ""
+			})
+
+			JustBeforeEach(func() {
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "go", "list", "-e", "-tags", "cloudfoundry", "-f", `{{if eq .Name "main"}}{{.ImportPath}}{{"\t"}}{{.Dir}}{{end}}`, ".").Do(func(_ string, buffer, _ io.Writer, _ string, _ ...string) {
+					if rootIsMain {
+						buffer.Write([]byte("example.com/app\t" + buildDir + "\n"))
+					}
+				}).Return(nil)
+
+				if !rootIsMain {
+					mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "go", "list", "-e", "-tags", "cloudfoundry", "-f", `{{if eq .Name "main"}}{{.ImportPath}}{{"\t"}}{{.Dir}}{{end}}`, "./...").Do(func(_ string, buffer, errorBuffer io.Writer, _ string, _ ...string) {
+						for _, dir := range mainDirs {
+							buffer.Write([]byte("example.com/app/" + dir + "\t" + filepath.Join(buildDir, dir) + "\n\n"))
+						}
+						errorBuffer.Write([]byte(listErrors))
+					}).Return(nil)
+				}
+			})
+
+			Context("the module root is a main package", func() {
+				BeforeEach(func() {
+					rootIsMain = true
+					mainDirs = []string{"cmd/tool"}
+				})
+
+				It("builds the root package as before, without listing the rest of the module", func() {
+					Expect(gf.SetInstallPackages(finalize.BuildpackConfig{})).To(Succeed())
+					Expect(gf.PackageList).To(Equal([]string{"."}))
+					Expect(buffer.String()).To(ContainSubstring("**WARNING** Installing package '.' (default)"))
+				})
+			})
+
+			Context("there is one main package", func() {
+				BeforeEach(func() {
+					mainDirs = []string{"cmd/api server"}
+				})
+
+				It("builds it", func() {
+					Expect(gf.SetInstallPackages(finalize.BuildpackConfig{})).To(Succeed())
+					Expect(gf.PackageList).To(Equal([]string{"./cmd/api server"}))
+					Expect(buffer.String()).To(ContainSubstring("-----> Installing package ./cmd/api server, the only main package in the module"))
+				})
+			})
+
+			Context("a sibling package does not build", func() {
+				BeforeEach(func() {
+					mainDirs = []string{"cmd/api"}
+					listErrors = "internal/broken/broken.go:2:6: expected 'IDENT', found '{'\n"
+				})
+
+				It("still finds the main package", func() {
+					Expect(gf.SetInstallPackages(finalize.BuildpackConfig{})).To(Succeed())
+					Expect(gf.PackageList).To(Equal([]string{"./cmd/api"}))
+				})
+			})
+
+			Context("there are several main packages", func() {
+				BeforeEach(func() {
+					mainDirs = []string{"cmd/worker", "cmd/api", "tools/migrate"}
+				})
+
+				It("builds them all with the web binary first", func() {
+					config := finalize.BuildpackConfig{Processes: map[string]finalize.ProcessConfig{"web": {Binary: "api"}}}
+					Expect(gf.SetInstallPackages(config)).To(Succeed())
+					Expect(gf.PackageList).To(Equal([]string{"./cmd/api", "./cmd/worker", "./tools/migrate"}))
+				})
+
+				It("lists the candidates when no web binary is set", func() {
+					err = gf.SetInstallPackages(finalize.BuildpackConfig{})
+					Expect(err).To(MatchError("found 3 main packages and no web process binary"))
+					Expect(buffer.String()).To(ContainSubstring("**ERROR** This module has no main package at its root and several under it:"))
+					Expect(buffer.String()).To(ContainSubstring("    ./cmd/api\n"))
+					Expect(buffer.String()).To(ContainSubstring("    ./tools/migrate\n"))
+				})
+
+				It("returns an error when the web binary is not one of them", func() {
+					config := finalize.BuildpackConfig{Processes: map[string]finalize.ProcessConfig{"web": {Binary: "server"}}}
+					err = gf.SetInstallPackages(config)
+					Expect(err).To(MatchError("web process binary server is not built by any main package"))
+				})
+			})
+		})
+
+		Context("the vendor tool is gomod and there is a package spec", func() {
+			var listMain func(patterns ...interface{}) *gomock.Call
+
+			BeforeEach(func() {
+				vendorTool = "gomod"
+				buildFlags = []string{"-tags", "cloudfoundry", "-buildmode", "pie"}
+
+				listMain = func(patterns ...interface{}) *gomock.Call {
+					args := append([]interface{}{"list", "-tags", "cloudfoundry", "-f", `{{if eq .Name "main"}}{{.ImportPath}}{{"\t"}}{{.Dir}}{{end}}`}, patterns...)
+					return mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "go", args...)
+				}
+			})
+
+			writeMain := func(dirs ...string) func(string, io.Writer, io.Writer, string, ...string) {
+				return func(_ string, buffer, _ io.Writer, _ string, _ ...string) {
+					for _, dir := range dirs {
+						buffer.Write([]byte("example.com/app/" + dir + "\t" + filepath.Join(buildDir, dir) + "\n"))
+					}
+					buffer.Write([]byte("\n"))
+				}
+			}
+
+			Context("GO_INSTALL_PACKAGE_SPEC has quoting and exclusions", func() {
+				BeforeEach(func() {
+					oldGoInstallPackageSpec := os.Getenv("GO_INSTALL_PACKAGE_SPEC")
+					Expect(os.Setenv("GO_INSTALL_PACKAGE_SPEC", `./cmd/... "./tools/my tool"  '!./cmd/devtool'`)).To(Succeed())
+					DeferCleanup(os.Setenv, "GO_INSTALL_PACKAGE_SPEC", oldGoInstallPackageSpec)
+				})
+
+				It("expands the patterns and keeps the main packages that are not excluded", func() {
+					listMain("./cmd/...", "./tools/my tool").Do(writeMain("cmd/api", "cmd/devtool", "cmd/worker", "tools/my tool")).Return(nil)
+					listMain("./cmd/devtool").Do(writeMain("cmd/devtool")).Return(nil)
+
+					Expect(gf.SetInstallPackages(finalize.BuildpackConfig{Packages: []string{"./ignored"}})).To(Succeed())
+					Expect(gf.PackageList).To(Equal([]string{"./cmd/api", "./cmd/worker", "./tools/my tool"}))
+
+					Expect(buffer.String()).To(ContainSubstring("-----> Installing packages from the package spec:\n       ./cmd/api\n       ./cmd/worker\n       ./tools/my tool\n"))
+				})
+			})
+
+			Context("GO_INSTALL_PACKAGE_SPEC has an unterminated quote", func() {
+				BeforeEach(func() {
+					oldGoInstallPackageSpec := os.Getenv("GO_INSTALL_PACKAGE_SPEC")
+					Expect(os.Setenv("GO_INSTALL_PACKAGE_SPEC", `./cmd/api "./cmd/my tool`)).To(Succeed())
+					DeferCleanup(os.Setenv, "GO_INSTALL_PACKAGE_SPEC", oldGoInstallPackageSpec)
+				})
+
+				It("returns an error", func() {
+					Expect(gf.SetInstallPackages(finalize.BuildpackConfig{})).To(MatchError(`unterminated " quote in package spec`))
+				})
+			})
+
+			Context("buildpack.yml lists packages", func() {
+				It("uses each entry as one pattern", func() {
+					listMain("./cmd/my api").Do(writeMain("cmd/my api")).Return(nil)
+
+					Expect(gf.SetInstallPackages(finalize.BuildpackConfig{Packages: []string{"./cmd/my api"}})).To(Succeed())
+					Expect(gf.PackageList).To(Equal([]string{"./cmd/my api"}))
+				})
+
+				It("returns an error when nothing main is left", func() {
+					listMain("./internal/...").Do(writeMain()).Return(nil)
+
+					Expect(gf.SetInstallPackages(finalize.BuildpackConfig{Packages: []string{"./internal/..."}})).To(MatchError("package spec matches no main packages"))
+				})
+			})
+		})
+
+		Context("the vendor tool is gopath", func() {
+			var mainDirs []string
+
+			BeforeEach(func() {
+				vendorTool = "gopath"
+				goPath = buildDir
+				buildFlags = []string{"-tags", "cloudfoundry", "-buildmode", "pie"}
+			})
+
+			JustBeforeEach(func() {
+				mockCommand.EXPECT().Execute(filepath.Join(buildDir, "src"), gomock.Any(), gomock.Any(), "go", "list", "-e", "-tags", "cloudfoundry", "-f", `{{if eq .Name "main"}}{{.ImportPath}}{{"\t"}}{{.Dir}}{{end}}`, "./...").Do(func(_ string, buffer, _ io.Writer, _ string, _ ...string) {
+					for _, dir := range mainDirs {
+						buffer.Write([]byte(dir + "\t" + filepath.Join(buildDir, "src", dir) + "\n"))
+					}
+				}).Return(nil).AnyTimes()
+			})
+
+			It("has no single main package name", func() {
+				Expect(gf.SetMainPackageName()).To(Succeed())
+				Expect(gf.MainPackageName).To(BeEmpty())
+			})
+
+			Context("there is one main package under src/", func() {
+				BeforeEach(func() {
+					mainDirs = []string{"myapp/cmd/server"}
+				})
+
+				It("builds it from src/", func() {
+					Expect(gf.SetInstallPackages(finalize.BuildpackConfig{})).To(Succeed())
+					Expect(gf.PackageList).To(Equal([]string{"./myapp/cmd/server"}))
+					Expect(buffer.String()).To(ContainSubstring("-----> Installing package ./myapp/cmd/server, the only main package in src/"))
+				})
+			})
+
+			Context("there are no main packages under src/", func() {
+				BeforeEach(func() {
+					mainDirs = nil
+				})
+
+				It("returns an error", func() {
+					Expect(gf.SetInstallPackages(finalize.BuildpackConfig{})).To(MatchError("found no main packages under src/"))
+				})
+			})
+		})
+
 		Context("the vendor tool is glide", func() {
 			BeforeEach(func() {
 				vendorTool = "glide"
@@ -207,6 +438,39 @@ config:
 			})
 
 			AssertRequiresAndUsesGOPACKAGENAME()
+
+			Context("Gopkg.toml names the project in its metadata", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "Gopkg.toml"), []byte(`[[constraint]]
+  name = "github.com/pkg/errors"
+  version = "0.8.0"
+
+[metadata]
+  name = "github.com/example/dep-app"
+`), 0644)).To(Succeed())
+				})
+
+				It("uses the metadata name", func() {
+					Expect(gf.SetMainPackageName()).To(Succeed())
+
+					Expect(gf.MainPackageName).To(Equal("github.com/example/dep-app"))
+					Expect(buffer.String()).To(ContainSubstring("-----> Using import path github.com/example/dep-app from the [metadata] name in Gopkg.toml"))
+				})
+
+				Context("GOPACKAGENAME is set", func() {
+					BeforeEach(func() {
+						DeferCleanup(os.Setenv, "GOPACKAGENAME", os.Getenv("GOPACKAGENAME"))
+						Expect(os.Setenv("GOPACKAGENAME", "my-go-app")).To(Succeed())
+					})
+
+					It("prefers GOPACKAGENAME", func() {
+						Expect(gf.SetMainPackageName()).To(Succeed())
+
+						Expect(gf.MainPackageName).To(Equal("my-go-app"))
+						Expect(buffer.String()).NotTo(ContainSubstring("Using import path"))
+					})
+				})
+			})
 		})
 
 		Context("the vendor tool is go_nativevendoring", func() {
@@ -215,6 +479,86 @@ config:
 			})
 
 			AssertRequiresAndUsesGOPACKAGENAME()
+
+			Context("GOPACKAGENAME is not set", func() {
+				writeFile := func(name, contents string) {
+					Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, name)), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, name), []byte(contents), 0644)).To(Succeed())
+				}
+
+				BeforeEach(func() {
+					DeferCleanup(os.Setenv, "GOPACKAGENAME", os.Getenv("GOPACKAGENAME"))
+					Expect(os.Unsetenv("GOPACKAGENAME")).To(Succeed())
+				})
+
+				Context("a package has a canonical import comment", func() {
+					BeforeEach(func() {
+						writeFile("main.go", "package main\n\nfunc main() {}\n")
+						writeFile("cmd/worker/main.go", "package main // import \"example.com/app/cmd/worker\"\n")
+						writeFile("vendor/vendor.json", `{"rootPath": "example.com/other"}`)
+					})
+
+					It("uses the import comment, less the package's directory", func() {
+						Expect(gf.SetMainPackageName()).To(Succeed())
+
+						Expect(gf.MainPackageName).To(Equal("example.com/app"))
+						Expect(buffer.String()).To(ContainSubstring("-----> Using import path example.com/app from the import comment in cmd/worker/main.go"))
+					})
+				})
+
+				Context("vendor/vendor.json has a rootPath", func() {
+					BeforeEach(func() {
+						writeFile("main.go", "package main\n\nfunc main() {}\n")
+						writeFile("vendor/vendor.json", `{"comment": "", "rootPath": "github.com/example/govendor-app", "package": []}`)
+					})
+
+					It("uses the rootPath", func() {
+						Expect(gf.SetMainPackageName()).To(Succeed())
+
+						Expect(gf.MainPackageName).To(Equal("github.com/example/govendor-app"))
+						Expect(buffer.String()).To(ContainSubstring("-----> Using import path github.com/example/govendor-app from the rootPath in vendor/vendor.json"))
+					})
+				})
+
+				Context("the app imports its own sub-packages", func() {
+					BeforeEach(func() {
+						writeFile("main.go", "package main\n\nimport (\n\t\"encoding/json\"\n\n\t\"github.com/example/app/internal/db\"\n)\n\nfunc main() {}\n")
+						writeFile("internal/db/db.go", "package db\n")
+						writeFile("json/json.go", "package json\n")
+					})
+
+					It("uses the import path the sub-package is imported with", func() {
+						Expect(gf.SetMainPackageName()).To(Succeed())
+
+						Expect(gf.MainPackageName).To(Equal("github.com/example/app"))
+						Expect(buffer.String()).To(ContainSubstring("-----> Using import path github.com/example/app from an import of one of the app's own packages in main.go"))
+					})
+				})
+
+				Context("a vendored dependency has a package named like one of the app's", func() {
+					BeforeEach(func() {
+						writeFile("main.go", "package main\n\nimport \"github.com/foo/bar/config\"\n\nfunc main() {}\n")
+						writeFile("config/config.go", "package config\n")
+						writeFile("vendor/github.com/foo/bar/config/config.go", "package config\n")
+					})
+
+					It("does not take the dependency's import path", func() {
+						Expect(gf.SetMainPackageName()).To(MatchError("GOPACKAGENAME unset"))
+						Expect(buffer.String()).To(ContainSubstring("**ERROR** To use go native vendoring set the $GOPACKAGENAME"))
+					})
+				})
+
+				Context("imports of the app's packages disagree on its import path", func() {
+					BeforeEach(func() {
+						writeFile("main.go", "package main\n\nimport \"github.com/example/app/internal/db\"\n\nfunc main() {}\n")
+						writeFile("internal/db/db.go", "package db\n\nimport \"github.com/other/lib/internal/db\"\n")
+					})
+
+					It("does not guess", func() {
+						Expect(gf.SetMainPackageName()).To(MatchError("GOPACKAGENAME unset"))
+					})
+				})
+			})
 		})
 
 		Context("the vendor tool is go modules", func() {
@@ -232,6 +576,23 @@ config:
 				Expect(err).To(BeNil())
 				Expect(gf.MainPackageName).To(Equal("go-package-name"))
 			})
+
+			Context("the module is in a subdirectory", func() {
+				BeforeEach(func() {
+					moduleDir = filepath.Join("services", "api")
+					DeferCleanup(func() { moduleDir = "" })
+				})
+
+				It("lists the module from the module dir", func() {
+					mockCommand.EXPECT().Execute(filepath.Join(buildDir, "services", "api"), gomock.Any(), gomock.Any(), "go", "list", "-m").Do(func(_ string, buffer, _ io.Writer, _ string, _ ...string) {
+						_, err := buffer.Write([]byte("example.com/monorepo/services/api\n"))
+						Expect(err).To(BeNil())
+					}).Return(nil)
+
+					Expect(gf.SetMainPackageName()).To(Succeed())
+					Expect(gf.MainPackageName).To(Equal("example.com/monorepo/services/api"))
+				})
+			})
 		})
 	})
 
@@ -320,6 +681,41 @@ config:
 				Expect(filepath.Join(gf.GoPath, "src", mainPackageName, "vendor", "lib.go")).To(BeAnExistingFile())
 			})
 
+			It("hard links the files instead of copying them", func() {
+				Expect(gf.SetupGoPath()).To(Succeed())
+
+				original, err := os.Stat(filepath.Join(buildDir, "vendor", "lib.go"))
+				Expect(err).To(BeNil())
+				linked, err := os.Stat(filepath.Join(gf.GoPath, "src", mainPackageName, "vendor", "lib.go"))
+				Expect(err).To(BeNil())
+				Expect(os.SameFile(original, linked)).To(BeTrue())
+
+				Expect(filepath.Join(gf.GoPath, "src", mainPackageName, "vendor")).To(BeADirectory())
+			})
+
+			It("recreates symlinks", func() {
+				Expect(os.Symlink("main.go", filepath.Join(buildDir, "link.go"))).To(Succeed())
+
+				Expect(gf.SetupGoPath()).To(Succeed())
+
+				target, err := os.Readlink(filepath.Join(gf.GoPath, "src", mainPackageName, "link.go"))
+				Expect(err).To(BeNil())
+				Expect(target).To(Equal("main.go"))
+			})
+
+			It("resolves vendored packages in the linked tree", func() {
+				Expect(os.MkdirAll(filepath.Join(buildDir, "vendor", "github.com", "lib"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "vendor", "github.com", "lib", "lib.go"), []byte("xx"), 0644)).To(Succeed())
+
+				DeferCleanup(os.Setenv, "GO_INSTALL_PACKAGE_SPEC", os.Getenv("GO_INSTALL_PACKAGE_SPEC"))
+				Expect(os.Setenv("GO_INSTALL_PACKAGE_SPEC", "github.com/lib .")).To(Succeed())
+
+				Expect(gf.SetupGoPath()).To(Succeed())
+				Expect(gf.SetInstallPackages(finalize.BuildpackConfig{})).To(Succeed())
+
+				Expect(gf.PackageList).To(Equal([]string{"a/package/name/vendor/github.com/lib", "."}))
+			})
+
 			It("sets GOBIN to <buildDir>/bin", func() {
 				err = gf.SetupGoPath()
 				Expect(err).To(BeNil())
@@ -396,6 +792,55 @@ config:
 				Expect(os.Getenv("GOBIN")).To(Equal(oldGoBin))
 			})
 		})
+
+		Context("the vendor tool is gopath", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(buildDir, "src", "myapp", "cmd", "server"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "src", "myapp", "cmd", "server", "main.go"), []byte("xx"), 0644)).To(Succeed())
+			})
+
+			JustBeforeEach(func() {
+				gf.VendorTool = "gopath"
+			})
+
+			It("uses the build dir as GOPATH without copying it", func() {
+				Expect(gf.SetupGoPath()).To(Succeed())
+
+				Expect(os.Getenv("GOPATH")).To(Equal(buildDir))
+				Expect(gf.GoPath).To(Equal(buildDir))
+				Expect(os.Getenv("GOBIN")).To(Equal(filepath.Join(buildDir, "bin")))
+				Expect(filepath.Join(buildDir, "src", mainPackageName)).NotTo(BeAnExistingFile())
+				Expect(filepath.Join(buildDir, "main.go")).To(BeAnExistingFile())
+				Expect(buffer.String()).To(ContainSubstring("-----> Using the app as a GOPATH, with packages under src/"))
+			})
+
+			Context("vendor/ is a gb-style GOPATH", func() {
+				BeforeEach(func() {
+					Expect(os.MkdirAll(filepath.Join(buildDir, "vendor", "src", "github.com", "lib"), 0755)).To(Succeed())
+				})
+
+				It("adds it to GOPATH after the app", func() {
+					Expect(gf.SetupGoPath()).To(Succeed())
+
+					Expect(os.Getenv("GOPATH")).To(Equal(buildDir + string(filepath.ListSeparator) + filepath.Join(buildDir, "vendor")))
+					Expect(gf.GoPath).To(Equal(buildDir))
+					Expect(buffer.String()).To(ContainSubstring("Adding vendor/ to GOPATH"))
+				})
+			})
+
+			Context("there is a src/vendor directory", func() {
+				BeforeEach(func() {
+					Expect(os.MkdirAll(filepath.Join(buildDir, "src", "vendor", "github.com", "lib"), 0755)).To(Succeed())
+				})
+
+				It("leaves it to the go tool", func() {
+					Expect(gf.SetupGoPath()).To(Succeed())
+
+					Expect(os.Getenv("GOPATH")).To(Equal(buildDir))
+					Expect(buffer.String()).To(ContainSubstring("Using vendored packages from src/vendor"))
+				})
+			})
+		})
 	})
 
 	Describe("SetBuildFlags", func() {
@@ -406,6 +851,29 @@ config:
 			})
 		})
 
+		Context("buildpack.yml sets build tags", func() {
+			It("adds them after the cloudfoundry tag", func() {
+				gf.SetBuildFlags(finalize.BuildpackConfig{LDFlags: map[string]string{}, Tags: []string{"prod", "metrics"}})
+				Expect(gf.BuildFlags).To(Equal([]string{"-tags", "cloudfoundry,prod,metrics", "-buildmode", "pie"}))
+			})
+		})
+
+		Context("buildpack.yml configures plugins", func() {
+			plugins := []finalize.PluginConfig{{Package: "./plugins/auth", Output: "plugins/auth.so"}}
+
+			It("turns off profile-guided optimization so the host matches its plugins", func() {
+				gf.GoVersion = "1.22.5"
+				gf.SetBuildFlags(finalize.BuildpackConfig{LDFlags: map[string]string{}, Plugins: plugins})
+				Expect(gf.BuildFlags).To(Equal([]string{"-tags", "cloudfoundry", "-buildmode", "pie", "-pgo", "off"}))
+			})
+
+			It("leaves the flags alone for go versions without default.pgo", func() {
+				gf.GoVersion = "1.20.14"
+				gf.SetBuildFlags(finalize.BuildpackConfig{LDFlags: map[string]string{}, Plugins: plugins})
+				Expect(gf.BuildFlags).To(Equal([]string{"-tags", "cloudfoundry", "-buildmode", "pie"}))
+			})
+		})
+
 		Context("link environment variables are set set", func() {
 			var (
 				oldGoLinkerSymbol string
@@ -459,55 +927,389 @@ config:
 		})
 	})
 
-	Describe("RunGlideInstall", func() {
-		var mainPackagePath string
+	Describe("SetBuildFlags in reproducible mode", func() {
+		var oldSourceDateEpoch string
 
 		BeforeEach(func() {
-			mainPackageName = "a/package/name"
-			goPath, err = os.MkdirTemp("", "go-buildpack.package")
-			Expect(err).To(BeNil())
-			DeferCleanup(os.RemoveAll, goPath)
-
-			mainPackagePath = filepath.Join(goPath, "src", mainPackageName)
-			err = os.MkdirAll(mainPackagePath, 0755)
-			Expect(err).To(BeNil())
+			oldSourceDateEpoch = os.Getenv("SOURCE_DATE_EPOCH")
+			DeferCleanup(os.Setenv, "SOURCE_DATE_EPOCH", oldSourceDateEpoch)
+		})
 
-			vendorTool = "glide"
+		It("adds -trimpath, pins the build id and sorts the ldflags", func() {
+			gf.SetBuildFlags(finalize.BuildpackConfig{Reproducible: true, LDFlags: map[string]string{
+				"main.b": "two",
+				"main.a": "one",
+			}})
+			Expect(gf.BuildFlags).To(Equal([]string{
+				"-tags", "cloudfoundry",
+				"-buildmode", "pie",
+				"-trimpath",
+				"-ldflags", "-X main.a=one -X main.b=two -buildid=",
+			}))
 		})
 
-		Context("packages are not already vendored", func() {
-			It("uses glide to install the packages", func() {
-				mockCommand.EXPECT().Execute(mainPackagePath, gomock.Any(), gomock.Any(), "glide", "install").Return(nil)
+		It("renders templated values from SOURCE_DATE_EPOCH", func() {
+			Expect(os.Setenv("SOURCE_DATE_EPOCH", "1700000000")).To(Succeed())
 
-				err = gf.RunGlideInstall()
-				Expect(err).To(BeNil())
-			})
+			gf.SetBuildFlags(finalize.BuildpackConfig{Reproducible: true, LDFlags: map[string]string{
+				"main.buildTime": "{{.BuildTime}}",
+			}})
+			Expect(gf.BuildFlags[len(gf.BuildFlags)-1]).To(Equal("-X main.buildTime=2023-11-14T22:13:20Z -buildid="))
 		})
 
-		Context("packages are already vendored", func() {
-			BeforeEach(func() {
-				err = os.MkdirAll(filepath.Join(mainPackagePath, "vendor", "another-package"), 0755)
-				Expect(err).To(BeNil())
-			})
+		It("falls back to the Unix epoch when SOURCE_DATE_EPOCH is unset", func() {
+			Expect(os.Unsetenv("SOURCE_DATE_EPOCH")).To(Succeed())
 
-			It("does not use glide to install the packages", func() {
-				err = gf.RunGlideInstall()
-				Expect(err).To(BeNil())
-			})
+			gf.SetBuildFlags(finalize.BuildpackConfig{Reproducible: true, LDFlags: map[string]string{
+				"main.buildTime": "{{.BuildTimestamp}}",
+			}})
+			Expect(gf.BuildFlags[len(gf.BuildFlags)-1]).To(Equal("-X main.buildTime=0 -buildid="))
 		})
 	})
 
-	Describe("RunDepEnsure", func() {
-		var mainPackagePath string
-
+	Describe("LogBinaryChecksums", func() {
 		BeforeEach(func() {
-			mainPackageName = "a/package/name"
-			goPath, err = os.MkdirTemp("", "go-buildpack.package")
+			err = os.MkdirAll(filepath.Join(buildDir, "bin"), 0755)
 			Expect(err).To(BeNil())
-			DeferCleanup(os.RemoveAll, goPath)
 
-			mainPackagePath = filepath.Join(goPath, "src", mainPackageName)
-			err = os.MkdirAll(mainPackagePath, 0755)
+			err = os.WriteFile(filepath.Join(buildDir, "bin", "app"), []byte("abc"), 0755)
+			Expect(err).To(BeNil())
+		})
+
+		It("logs the sha256 of each binary", func() {
+			err = gf.LogBinaryChecksums()
+			Expect(err).To(BeNil())
+
+			Expect(buffer.String()).To(ContainSubstring("sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad  bin/app"))
+		})
+	})
+
+	Describe("InstallTools", func() {
+		var oldGoFlags string
+
+		BeforeEach(func() {
+			vendorTool = "gomod"
+			Expect(os.MkdirAll(filepath.Join(buildDir, "bin"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "go.mod"), []byte("module example.com/app\n\ngo 1.24\n\ntool golang.org/x/tools/cmd/stringer\n"), 0644)).To(Succeed())
+
+			oldGoFlags = os.Getenv("GOFLAGS")
+			Expect(os.Setenv("GOFLAGS", "-mod=vendor")).To(Succeed())
+			DeferCleanup(os.Setenv, "GOFLAGS", oldGoFlags)
+		})
+
+		writeTool := func(name string) func(string, io.Writer, io.Writer, string, ...string) {
+			return func(_ string, _, _ io.Writer, _ string, args ...string) {
+				dir := os.Getenv("GOBIN")
+				if args[0] == "build" {
+					dir = args[2]
+				}
+				Expect(os.WriteFile(filepath.Join(dir, name), []byte("tool"), 0755)).To(Succeed())
+			}
+		}
+
+		It("builds go.mod tools and pinned tools into <buildDir>/bin", func() {
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "go", "build", "-o", gomock.Any(), "tool").Do(writeTool("stringer")).Return(nil)
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "go", "install", "github.com/pressly/goose/v3/cmd/goose@v3.21.1").Do(func(_ string, _, _ io.Writer, _ string, _ ...string) {
+				Expect(os.Getenv("GOFLAGS")).To(BeEmpty())
+				writeTool("goose")("", nil, nil, "", "install")
+			}).Return(nil)
+
+			err = gf.InstallTools([]string{"github.com/pressly/goose/v3/cmd/goose@v3.21.1"})
+			Expect(err).To(BeNil())
+
+			Expect(filepath.Join(buildDir, "bin", "stringer")).To(BeAnExistingFile())
+			Expect(filepath.Join(buildDir, "bin", "goose")).To(BeAnExistingFile())
+			Expect(os.Getenv("GOFLAGS")).To(Equal("-mod=vendor"))
+		})
+
+		It("refuses to overwrite an app binary", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "bin", "stringer"), []byte("app"), 0755)).To(Succeed())
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "go", "build", "-o", gomock.Any(), "tool").Do(writeTool("stringer")).Return(nil)
+
+			err = gf.InstallTools(nil)
+			Expect(err).To(MatchError("tool stringer would overwrite bin/stringer"))
+		})
+
+		It("requires pinned tools to have a version", func() {
+			config := finalize.BuildpackConfig{Tools: []string{"github.com/pressly/goose/v3/cmd/goose@latest"}}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("must be pinned as module@version")))
+		})
+	})
+
+	Describe("BuildPlugins", func() {
+		BeforeEach(func() {
+			vendorTool = "gomod"
+			buildFlags = []string{"-tags", "cloudfoundry", "-buildmode", "pie", "-trimpath"}
+			DeferCleanup(func() {
+				buildFlags = []string{}
+			})
+		})
+
+		It("builds each plugin with the main build flags in plugin mode", func() {
+			output := filepath.Join(buildDir, "plugins", "auth.so")
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "go", "build", "-tags", "cloudfoundry", "-trimpath", "-buildmode", "plugin", "-o", output, "./plugins/auth").Return(nil)
+
+			err = gf.BuildPlugins([]finalize.PluginConfig{{Package: "./plugins/auth", Output: "plugins/auth.so"}})
+			Expect(err).To(BeNil())
+
+			Expect(filepath.Join(buildDir, "plugins")).To(BeADirectory())
+			Expect(gf.PluginOutputs).To(Equal([]string{"plugins/auth.so"}))
+			Expect(buffer.String()).To(ContainSubstring("-----> Running: go build -tags cloudfoundry -trimpath -buildmode plugin -o " + output + " ./plugins/auth"))
+		})
+
+		It("keeps plugin outputs when pruning", func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "plugins"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "plugins", "auth.so"), []byte("so"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "plugins", "auth.go"), []byte("go"), 0644)).To(Succeed())
+			gf.PluginOutputs = []string{"plugins/auth.so"}
+
+			err = gf.PruneBuildDir(finalize.PruneConfig{Enabled: true})
+			Expect(err).To(BeNil())
+
+			Expect(filepath.Join(buildDir, "plugins", "auth.so")).To(BeAnExistingFile())
+			Expect(filepath.Join(buildDir, "plugins", "auth.go")).NotTo(BeAnExistingFile())
+		})
+
+		Describe("Validate", func() {
+			It("requires an output path inside the app", func() {
+				Expect(finalize.PluginConfig{Package: "./plugins/auth"}.Validate()).To(MatchError("plugin ./plugins/auth needs an output path"))
+				Expect(finalize.PluginConfig{Package: "./plugins/auth", Output: "/tmp/auth.so"}.Validate()).To(MatchError(`plugin output "/tmp/auth.so" must be relative to the app`))
+			})
+
+			It("rejects plugins alongside pgo or cover builds", func() {
+				plugins := []finalize.PluginConfig{{Package: "./plugins/auth", Output: "plugins/auth.so"}}
+				Expect(finalize.BuildpackConfig{Plugins: plugins}.Validate()).To(Succeed())
+				Expect(finalize.BuildpackConfig{Plugins: plugins, PGO: map[string]string{".": "default.pgo"}}.Validate()).To(MatchError("plugins: cannot be combined with pgo or cover builds"))
+				Expect(finalize.BuildpackConfig{Plugins: plugins, Cover: finalize.CoverConfig{Packages: []string{"."}}}.Validate()).To(MatchError("plugins: cannot be combined with pgo or cover builds"))
+			})
+		})
+	})
+
+	Describe("StripBinaries", func() {
+		var (
+			binDir   string
+			cacheDir string
+		)
+
+		BeforeEach(func() {
+			binDir = filepath.Join(buildDir, "bin")
+			err = os.MkdirAll(binDir, 0755)
+			Expect(err).To(BeNil())
+
+			err = os.WriteFile(filepath.Join(binDir, "app"), append([]byte("\x7fELF"), bytes.Repeat([]byte("x"), 2044)...), 0755)
+			Expect(err).To(BeNil())
+
+			cacheDir, err = os.MkdirTemp("", "go-buildpack.cache")
+			Expect(err).To(BeNil())
+			DeferCleanup(os.RemoveAll, cacheDir)
+		})
+
+		JustBeforeEach(func() {
+			gf.Stager = libbuildpack.NewStager([]string{buildDir, cacheDir, depsDir, depsIdx}, logger, &libbuildpack.Manifest{})
+		})
+
+		expectStrip := func() {
+			binary := filepath.Join(binDir, "app")
+			mockCommand.EXPECT().Execute(binDir, gomock.Any(), gomock.Any(), "strip", "--strip-all", "-o", binary, binary+".debug").Do(func(_ string, _, _ io.Writer, _ string, _ ...string) {
+				Expect(os.WriteFile(binary, []byte("stripped"), 0755)).To(Succeed())
+			}).Return(nil)
+		}
+
+		Context("the mode is post", func() {
+			It("strips the binaries and reports the sizes", func() {
+				expectStrip()
+
+				err = gf.StripBinaries(finalize.StripConfig{Mode: "post"})
+				Expect(err).To(BeNil())
+
+				Expect(buffer.String()).To(ContainSubstring("bin/app: 2.0 KiB -> 8 B"))
+				Expect(filepath.Join(binDir, "app.debug")).NotTo(BeAnExistingFile())
+			})
+
+			It("leaves files that are not ELF binaries alone", func() {
+				Expect(os.WriteFile(filepath.Join(binDir, "start.sh"), []byte("#!/bin/sh\nexec ./bin/app\n"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(binDir, "empty"), nil, 0755)).To(Succeed())
+				expectStrip()
+
+				Expect(gf.StripBinaries(finalize.StripConfig{Mode: "post"})).To(Succeed())
+
+				contents, err := os.ReadFile(filepath.Join(binDir, "start.sh"))
+				Expect(err).To(BeNil())
+				Expect(string(contents)).To(Equal("#!/bin/sh\nexec ./bin/app\n"))
+				Expect(buffer.String()).NotTo(ContainSubstring("bin/start.sh"))
+			})
+
+			Context("symbols are kept in the cache", func() {
+				It("stores the unstripped binary keyed by the stripped binary's hash", func() {
+					expectStrip()
+
+					err = gf.StripBinaries(finalize.StripConfig{Mode: "post", Symbols: "cache"})
+					Expect(err).To(BeNil())
+
+					// sha256 of "stripped"
+					debugCopy := filepath.Join(cacheDir, "debug-symbols", "8531d8960e7f2447508d80e80d48fd96730cf89a9987268971d858fc49cba71a", "app")
+
+					contents, err := os.ReadFile(debugCopy)
+					Expect(err).To(BeNil())
+					Expect(contents).To(HaveLen(2048))
+					Expect(filepath.Join(binDir, "app.debug")).NotTo(BeAnExistingFile())
+				})
+
+				It("succeeds when bin/ has no ELF binaries to strip", func() {
+					Expect(os.WriteFile(filepath.Join(binDir, "app"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+
+					Expect(gf.StripBinaries(finalize.StripConfig{Mode: "post", Symbols: "cache"})).To(Succeed())
+					Expect(filepath.Join(cacheDir, "debug-symbols")).NotTo(BeAnExistingFile())
+				})
+			})
+		})
+
+		Context("the mode is linker", func() {
+			It("reports the size without running strip", func() {
+				err = gf.StripBinaries(finalize.StripConfig{Mode: "linker"})
+				Expect(err).To(BeNil())
+
+				Expect(buffer.String()).To(ContainSubstring("bin/app: 2.0 KiB (stripped at link time, no unstripped size to compare)"))
+			})
+
+			It("adds -s -w to the ldflags", func() {
+				gf.SetBuildFlags(finalize.BuildpackConfig{LDFlags: map[string]string{}, Strip: finalize.StripConfig{Mode: "linker"}})
+				Expect(gf.BuildFlags).To(Equal([]string{"-tags", "cloudfoundry", "-buildmode", "pie", "-ldflags", "-s -w"}))
+			})
+		})
+
+		Describe("Validate", func() {
+			It("rejects unknown modes", func() {
+				Expect(finalize.StripConfig{Mode: "all"}.Validate()).To(MatchError(ContainSubstring(`unknown strip mode "all"`)))
+			})
+
+			It("rejects keeping symbols when stripping at link time", func() {
+				Expect(finalize.StripConfig{Mode: "linker", Symbols: "cache"}.Validate()).To(MatchError("symbols can only be kept when the strip mode is post"))
+			})
+
+			It("rejects relative symbol directories", func() {
+				Expect(finalize.StripConfig{Mode: "post", Symbols: "symbols"}.Validate()).NotTo(Succeed())
+			})
+		})
+	})
+
+	Describe("SetMicroarchitecture", func() {
+		var oldGOARCH, oldGOAMD64, oldCFStack string
+
+		BeforeEach(func() {
+			oldGOARCH = os.Getenv("GOARCH")
+			oldGOAMD64 = os.Getenv("GOAMD64")
+			oldCFStack = os.Getenv("CF_STACK")
+			Expect(os.Setenv("GOARCH", "amd64")).To(Succeed())
+			Expect(os.Unsetenv("GOAMD64")).To(Succeed())
+			Expect(os.Setenv("CF_STACK", "cflinuxfs4")).To(Succeed())
+			DeferCleanup(func() {
+				Expect(os.Setenv("GOARCH", oldGOARCH)).To(Succeed())
+				Expect(os.Setenv("GOAMD64", oldGOAMD64)).To(Succeed())
+				Expect(os.Setenv("CF_STACK", oldCFStack)).To(Succeed())
+			})
+		})
+
+		It("uses the stack default", func() {
+			err = gf.SetMicroarchitecture(finalize.BuildpackConfig{})
+			Expect(err).To(BeNil())
+
+			Expect(os.Getenv("GOAMD64")).To(Equal("v1"))
+			Expect(gf.RequiredCPUFeatures).To(BeEmpty())
+			Expect(buffer.String()).To(ContainSubstring("-----> Targeting GOAMD64=v1 (from stack)"))
+		})
+
+		It("prefers the environment over the stack default", func() {
+			Expect(os.Setenv("GOAMD64", "v2")).To(Succeed())
+
+			err = gf.SetMicroarchitecture(finalize.BuildpackConfig{})
+			Expect(err).To(BeNil())
+
+			Expect(gf.MicroarchLevel).To(Equal("GOAMD64=v2"))
+			Expect(gf.RequiredCPUFeatures).To(ConsistOf("cx16", "lahf_lm", "popcnt", "sse4_1", "sse4_2", "ssse3"))
+		})
+
+		It("prefers buildpack.yml over the environment", func() {
+			Expect(os.Setenv("GOAMD64", "v2")).To(Succeed())
+
+			err = gf.SetMicroarchitecture(finalize.BuildpackConfig{GOAMD64: "v3"})
+			Expect(err).To(BeNil())
+
+			Expect(os.Getenv("GOAMD64")).To(Equal("v3"))
+			Expect(gf.RequiredCPUFeatures).To(ContainElements("sse4_2", "avx2", "fma"))
+			Expect(buffer.String()).To(ContainSubstring("-----> Targeting GOAMD64=v3 (from buildpack.yml)"))
+		})
+
+		It("rejects invalid levels", func() {
+			err = gf.SetMicroarchitecture(finalize.BuildpackConfig{GOAMD64: "v5"})
+			Expect(err).To(MatchError(ContainSubstring(`invalid GOAMD64 level "v5"`)))
+		})
+
+		Context("the stack is arm64", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("GOARCH", "arm64")).To(Succeed())
+				DeferCleanup(os.Unsetenv, "GOARM64")
+			})
+
+			It("requires atomics from v8.1", func() {
+				err = gf.SetMicroarchitecture(finalize.BuildpackConfig{GOARM64: "v8.2,crypto"})
+				Expect(err).To(BeNil())
+
+				Expect(os.Getenv("GOARM64")).To(Equal("v8.2,crypto"))
+				Expect(gf.RequiredCPUFeatures).To(Equal([]string{"atomics", "aes", "pmull", "sha1", "sha2"}))
+			})
+		})
+	})
+
+	Describe("RunGlideInstall", func() {
+		var mainPackagePath string
+
+		BeforeEach(func() {
+			mainPackageName = "a/package/name"
+			goPath, err = os.MkdirTemp("", "go-buildpack.package")
+			Expect(err).To(BeNil())
+			DeferCleanup(os.RemoveAll, goPath)
+
+			mainPackagePath = filepath.Join(goPath, "src", mainPackageName)
+			err = os.MkdirAll(mainPackagePath, 0755)
+			Expect(err).To(BeNil())
+
+			vendorTool = "glide"
+		})
+
+		Context("packages are not already vendored", func() {
+			It("uses glide to install the packages", func() {
+				mockCommand.EXPECT().Execute(mainPackagePath, gomock.Any(), gomock.Any(), "glide", "install").Return(nil)
+
+				err = gf.RunGlideInstall()
+				Expect(err).To(BeNil())
+			})
+		})
+
+		Context("packages are already vendored", func() {
+			BeforeEach(func() {
+				err = os.MkdirAll(filepath.Join(mainPackagePath, "vendor", "another-package"), 0755)
+				Expect(err).To(BeNil())
+			})
+
+			It("does not use glide to install the packages", func() {
+				err = gf.RunGlideInstall()
+				Expect(err).To(BeNil())
+			})
+		})
+	})
+
+	Describe("RunDepEnsure", func() {
+		var mainPackagePath string
+
+		BeforeEach(func() {
+			mainPackageName = "a/package/name"
+			goPath, err = os.MkdirTemp("", "go-buildpack.package")
+			Expect(err).To(BeNil())
+			DeferCleanup(os.RemoveAll, goPath)
+
+			mainPackagePath = filepath.Join(goPath, "src", mainPackageName)
+			err = os.MkdirAll(mainPackagePath, 0755)
 			Expect(err).To(BeNil())
 
 			vendorTool = "dep"
@@ -651,13 +1453,13 @@ config:
 				})
 
 				It("sets the packages from the env var", func() {
-					err = gf.SetInstallPackages()
+					err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 					Expect(err).To(BeNil())
 					Expect(gf.PackageList).To(Equal([]string{"a-package-name", "another-package"}))
 				})
 
 				It("logs a warning that it overrode the Godeps.json packages", func() {
-					err := gf.SetInstallPackages()
+					err := gf.SetInstallPackages(finalize.BuildpackConfig{})
 					Expect(err).To(BeNil())
 					Expect(buffer.String()).To(ContainSubstring("**WARNING** Using $GO_INSTALL_PACKAGE_SPEC override."))
 					Expect(buffer.String()).To(ContainSubstring("    $GO_INSTALL_PACKAGE_SPEC = a-package-name"))
@@ -677,13 +1479,13 @@ config:
 					})
 
 					It("sets packages to the default", func() {
-						err = gf.SetInstallPackages()
+						err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 						Expect(err).To(BeNil())
 						Expect(gf.PackageList).To(Equal([]string{"."}))
 					})
 
 					It("logs a warning that it is using the default", func() {
-						err = gf.SetInstallPackages()
+						err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 						Expect(err).To(BeNil())
 						Expect(buffer.String()).To(ContainSubstring("**WARNING** Installing package '.' (default)"))
 					})
@@ -691,7 +1493,7 @@ config:
 
 				Context("there is no vendor directory and no Godeps workspace", func() {
 					It("logs a warning that ther is no vendor directory", func() {
-						err = gf.SetInstallPackages()
+						err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 						Expect(err).To(BeNil())
 
 						Expect(buffer.String()).To(ContainSubstring("**WARNING** vendor/ directory does not exist"))
@@ -705,7 +1507,7 @@ config:
 					})
 
 					It("handles the vendoring correctly", func() {
-						err = gf.SetInstallPackages()
+						err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 						Expect(err).To(BeNil())
 
 						Expect(gf.PackageList).To(Equal([]string{filepath.Join(mainPackageName, "vendor", "foo"), "bar"}))
@@ -717,14 +1519,14 @@ config:
 						})
 
 						It("uses the packages from Godeps.json", func() {
-							err = gf.SetInstallPackages()
+							err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 							Expect(err).To(BeNil())
 
 							Expect(gf.PackageList).To(Equal([]string{"foo", "bar"}))
 						})
 
 						It("logs a warning about vendor and godeps both existing", func() {
-							err = gf.SetInstallPackages()
+							err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 							Expect(err).To(BeNil())
 
 							Expect(buffer.String()).To(ContainSubstring("**WARNING** Godeps/_workspace/src and vendor/ exist"))
@@ -738,7 +1540,7 @@ config:
 						})
 
 						It("uses the packages from Godeps.json", func() {
-							err = gf.SetInstallPackages()
+							err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 							Expect(err).To(BeNil())
 
 							Expect(gf.PackageList).To(Equal([]string{"foo", "bar"}))
@@ -752,14 +1554,14 @@ config:
 					})
 
 					It("uses the packages from Godeps.json", func() {
-						err = gf.SetInstallPackages()
+						err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 						Expect(err).To(BeNil())
 
 						Expect(gf.PackageList).To(Equal([]string{"foo", "bar"}))
 					})
 
 					It("doesn't log any warnings", func() {
-						err = gf.SetInstallPackages()
+						err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 						Expect(err).To(BeNil())
 
 						Expect(buffer.String()).To(Equal(""))
@@ -792,7 +1594,7 @@ config:
 						Expect(err).To(BeNil())
 					})
 					It("handles the vendoring correctly", func() {
-						err = gf.SetInstallPackages()
+						err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 						Expect(err).To(BeNil())
 
 						Expect(gf.PackageList).To(Equal([]string{"a-package-name", filepath.Join(mainPackageName, "vendor", "another-package")}))
@@ -800,7 +1602,7 @@ config:
 				})
 				Context("packages are not vendored", func() {
 					It("sets the packages", func() {
-						err = gf.SetInstallPackages()
+						err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 						Expect(err).To(BeNil())
 
 						Expect(gf.PackageList).To(Equal([]string{"a-package-name", "another-package"}))
@@ -810,13 +1612,13 @@ config:
 
 			Context("GO_INSTALL_PACKAGE_SPEC is not set", func() {
 				It("sets packages to  default", func() {
-					err = gf.SetInstallPackages()
+					err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 					Expect(err).To(BeNil())
 					Expect(gf.PackageList).To(Equal([]string{"."}))
 				})
 
 				It("logs a warning that it is using the default", func() {
-					err = gf.SetInstallPackages()
+					err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 					Expect(err).To(BeNil())
 					Expect(buffer.String()).To(ContainSubstring("**WARNING** Installing package '.' (default)"))
 				})
@@ -828,7 +1630,7 @@ config:
 				})
 
 				It("logs a error and returns an error", func() {
-					err = gf.SetInstallPackages()
+					err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 					Expect(err).NotTo(BeNil())
 
 					Expect(buffer.String()).To(ContainSubstring("**ERROR** $GO15VENDOREXPERIMENT=0. To vendor your packages in vendor/"))
@@ -861,7 +1663,7 @@ config:
 						Expect(err).To(BeNil())
 					})
 					It("handles the vendoring correctly", func() {
-						err = gf.SetInstallPackages()
+						err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 						Expect(err).To(BeNil())
 
 						Expect(gf.PackageList).To(Equal([]string{"a-package-name", filepath.Join(mainPackageName, "vendor", "another-package")}))
@@ -869,7 +1671,7 @@ config:
 				})
 				Context("packages are not vendored", func() {
 					It("sets the packages", func() {
-						err = gf.SetInstallPackages()
+						err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 						Expect(err).To(BeNil())
 
 						Expect(gf.PackageList).To(Equal([]string{"a-package-name", "another-package"}))
@@ -879,13 +1681,13 @@ config:
 
 			Context("GO_INSTALL_PACKAGE_SPEC is not set", func() {
 				It("sets packages to  default", func() {
-					err = gf.SetInstallPackages()
+					err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 					Expect(err).To(BeNil())
 					Expect(gf.PackageList).To(Equal([]string{"."}))
 				})
 
 				It("logs a warning that it is using the default", func() {
-					err = gf.SetInstallPackages()
+					err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 					Expect(err).To(BeNil())
 					Expect(buffer.String()).To(ContainSubstring("**WARNING** Installing package '.' (default)"))
 				})
@@ -912,7 +1714,7 @@ config:
 
 				Context("packages are not vendored", func() {
 					It("sets the packages in the compiler", func() {
-						err = gf.SetInstallPackages()
+						err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 						Expect(err).To(BeNil())
 
 						Expect(gf.PackageList).To(Equal([]string{"a-package-name", "another-package"}))
@@ -925,7 +1727,7 @@ config:
 						Expect(err).To(BeNil())
 					})
 					It("handles the vendoring correctly", func() {
-						err = gf.SetInstallPackages()
+						err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 						Expect(err).To(BeNil())
 
 						Expect(gf.PackageList).To(Equal([]string{"a-package-name", filepath.Join(mainPackageName, "vendor", "another-package")}))
@@ -933,15 +1735,60 @@ config:
 				})
 
 			})
+			Context("GO_INSTALL_PACKAGE_SPEC excludes a package", func() {
+				BeforeEach(func() {
+					oldGoInstallPackageSpec := os.Getenv("GO_INSTALL_PACKAGE_SPEC")
+					Expect(os.Setenv("GO_INSTALL_PACKAGE_SPEC", "a-package-name another-package !another-package")).To(Succeed())
+					DeferCleanup(os.Setenv, "GO_INSTALL_PACKAGE_SPEC", oldGoInstallPackageSpec)
+				})
+
+				It("drops it from the packages", func() {
+					err = gf.SetInstallPackages(finalize.BuildpackConfig{})
+					Expect(err).To(BeNil())
+					Expect(gf.PackageList).To(Equal([]string{"a-package-name"}))
+				})
+			})
+
+			Context("GO_INSTALL_PACKAGE_SPEC expands a wildcard and excludes one of its packages", func() {
+				BeforeEach(func() {
+					oldGoInstallPackageSpec := os.Getenv("GO_INSTALL_PACKAGE_SPEC")
+					Expect(os.Setenv("GO_INSTALL_PACKAGE_SPEC", "./cmd/... !./cmd/devtool")).To(Succeed())
+					DeferCleanup(os.Setenv, "GO_INSTALL_PACKAGE_SPEC", oldGoInstallPackageSpec)
+				})
+
+				It("keeps the main packages that are not excluded", func() {
+					mockCommand.EXPECT().Execute(mainPackagePath, gomock.Any(), gomock.Any(), "go", "list", "-f", `{{if eq .Name "main"}}{{.ImportPath}}{{"\t"}}{{.Dir}}{{end}}`, "./cmd/...").Do(func(_ string, buffer, _ io.Writer, _ string, _ ...string) {
+						for _, dir := range []string{"cmd/api", "cmd/devtool"} {
+							buffer.Write([]byte("a/package/name/" + dir + "\t" + filepath.Join(mainPackagePath, dir) + "\n"))
+						}
+					}).Return(nil)
+
+					Expect(gf.SetInstallPackages(finalize.BuildpackConfig{})).To(Succeed())
+					Expect(gf.PackageList).To(Equal([]string{"./cmd/api"}))
+				})
+			})
+
+			Context("GO_INSTALL_PACKAGE_SPEC excludes a package it does not name", func() {
+				BeforeEach(func() {
+					oldGoInstallPackageSpec := os.Getenv("GO_INSTALL_PACKAGE_SPEC")
+					Expect(os.Setenv("GO_INSTALL_PACKAGE_SPEC", "a-package-name !another-package")).To(Succeed())
+					DeferCleanup(os.Setenv, "GO_INSTALL_PACKAGE_SPEC", oldGoInstallPackageSpec)
+				})
+
+				It("returns an error", func() {
+					Expect(gf.SetInstallPackages(finalize.BuildpackConfig{})).To(MatchError("package spec exclusion !another-package matches none of the packages to install"))
+				})
+			})
+
 			Context("GO_INSTALL_PACKAGE_SPEC is not set", func() {
 				It("returns default", func() {
-					err = gf.SetInstallPackages()
+					err = gf.SetInstallPackages(finalize.BuildpackConfig{})
 					Expect(err).To(BeNil())
 					Expect(gf.PackageList).To(Equal([]string{"."}))
 				})
 
 				It("logs a warning that it is using the default", func() {
-					err := gf.SetInstallPackages()
+					err := gf.SetInstallPackages(finalize.BuildpackConfig{})
 					Expect(err).To(BeNil())
 					Expect(buffer.String()).To(ContainSubstring("**WARNING** Installing package '.' (default)"))
 				})
@@ -1041,6 +1888,51 @@ config:
 			})
 		}
 
+		Context("a package has a PGO profile", func() {
+			BeforeEach(func() {
+				vendorTool = "go_nativevendoring"
+			})
+
+			JustBeforeEach(func() {
+				gf.PGOProfiles = map[string]string{"second": "/app/second.pprof"}
+			})
+
+			It("installs that package separately with -pgo", func() {
+				gomock.InOrder(
+					mockCommand.EXPECT().Execute(mainPackagePath, gomock.Any(), gomock.Any(), "go", "install", "-a=1", "-b=2", "first").Return(nil),
+					mockCommand.EXPECT().Execute(mainPackagePath, gomock.Any(), gomock.Any(), "go", "install", "-a=1", "-b=2", "-pgo", "/app/second.pprof", "second").Return(nil),
+				)
+
+				err = gf.CompileApp()
+				Expect(err).To(BeNil())
+
+				Expect(buffer.String()).To(ContainSubstring("Built second with profile-guided optimization (/app/second.pprof)"))
+			})
+		})
+
+		Context("a package is built with coverage", func() {
+			BeforeEach(func() {
+				vendorTool = "go_nativevendoring"
+			})
+
+			JustBeforeEach(func() {
+				gf.CoverPackages = map[string]bool{"first": true}
+				gf.CoverPkg = []string{"first/...", "lib/..."}
+			})
+
+			It("installs that package separately with -cover", func() {
+				gomock.InOrder(
+					mockCommand.EXPECT().Execute(mainPackagePath, gomock.Any(), gomock.Any(), "go", "install", "-a=1", "-b=2", "second").Return(nil),
+					mockCommand.EXPECT().Execute(mainPackagePath, gomock.Any(), gomock.Any(), "go", "install", "-a=1", "-b=2", "-cover", "-coverpkg", "first/...,lib/...", "first").Return(nil),
+				)
+
+				err = gf.CompileApp()
+				Expect(err).To(BeNil())
+
+				Expect(buffer.String()).To(ContainSubstring("Built first with coverage instrumentation"))
+			})
+		})
+
 		Context("the tool is glide", func() {
 			BeforeEach(func() {
 				vendorTool = "glide"
@@ -1063,44 +1955,830 @@ config:
 		})
 	})
 
-	Describe("CreateStartupEnvironment", func() {
-		var tempDir string
+	Describe("CheckCgo", func() {
+		var oldCgoCFlags, oldCgoEnabled string
 
 		BeforeEach(func() {
-			goVersion = "3.4.5"
-			mainPackageName = "a-go-app"
-			goPath = buildDir
+			vendorTool = "gomod"
+			packageList = []string{"./cmd/api"}
+			buildFlags = []string{"-tags", "cloudfoundry", "-buildmode", "pie"}
 
-			goDir := filepath.Join(depsDir, depsIdx, "go"+goVersion, "go")
-			err = os.MkdirAll(goDir, 0755)
-			Expect(err).To(BeNil())
+			oldCgoCFlags = os.Getenv("CGO_CFLAGS")
+			oldCgoEnabled = os.Getenv("CGO_ENABLED")
+			Expect(os.Setenv("CGO_CFLAGS", "-O2")).To(Succeed())
+			Expect(os.Unsetenv("CGO_ENABLED")).To(Succeed())
 
-			tempDir, err = os.MkdirTemp("", "gobuildpack.releaseyml")
-			Expect(err).To(BeNil())
+			DeferCleanup(func() {
+				packageList = []string{}
+				buildFlags = []string{}
+				Expect(os.Setenv("CGO_CFLAGS", oldCgoCFlags)).To(Succeed())
+				Expect(os.Setenv("CGO_ENABLED", oldCgoEnabled)).To(Succeed())
+			})
 		})
 
-		It("writes the buildpack-release-step.yml file", func() {
-			err = gf.CreateStartupEnvironment(tempDir)
-			Expect(err).To(BeNil())
+		expectGoList := func(output string) {
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "go", "list", "-deps", "-tags", "cloudfoundry", "-f", `{{if and .CgoFiles (not .Standard)}}{{.ImportPath}} {{join .CgoPkgConfig " "}}{{end}}`, "./cmd/api").Do(func(_ string, buffer, _ io.Writer, _ string, _ ...string) {
+				_, err := buffer.Write([]byte(output))
+				Expect(err).To(BeNil())
+			}).Return(nil)
+		}
 
-			contents, err := os.ReadFile(filepath.Join(tempDir, "buildpack-release-step.yml"))
+		It("appends the cgo flags from buildpack.yml to the environment", func() {
+			expectGoList("")
+
+			err = gf.CheckCgo(finalize.BuildpackConfig{Cgo: finalize.CgoConfig{CFlags: "-I/opt/include"}})
 			Expect(err).To(BeNil())
 
-			yaml := `---
-default_process_types:
-    web: ./bin/a-go-app
-`
-			Expect(string(contents)).To(Equal(yaml))
+			Expect(os.Getenv("CGO_CFLAGS")).To(Equal("-O2 -I/opt/include"))
 		})
 
-		It("writes the go.sh script to <depDir>/profile.d", func() {
-			err = gf.CreateStartupEnvironment(tempDir)
-			Expect(err).To(BeNil())
-
-			contents, err := os.ReadFile(filepath.Join(gf.Stager.DepDir(), "profile.d", "go.sh"))
-			Expect(err).To(BeNil())
+		Context("no packages use cgo", func() {
+			It("does not check for a C toolchain", func() {
+				expectGoList("\n\n")
 
-			Expect(string(contents)).To(Equal("PATH=$PATH:$HOME/bin\n"))
+				err = gf.CheckCgo(finalize.BuildpackConfig{})
+				Expect(err).To(BeNil())
+			})
+		})
+
+		Context("the app relies on the Godeps workspace", func() {
+			BeforeEach(func() {
+				vendorTool = "godep"
+				godepConfig = godep.Godep{ImportPath: "go-online", WorkspaceExists: true}
+				mainPackageName = "go-online"
+				goPath = filepath.Join(buildDir, "gopath")
+
+				DeferCleanup(func() {
+					mainPackageName = ""
+					goPath = ""
+				})
+			})
+
+			It("lists the packages through godep, as the build does", func() {
+				mockCommand.EXPECT().Execute(filepath.Join(goPath, "src", "go-online"), gomock.Any(), gomock.Any(), "godep", "go", "list", "-deps", "-tags", "cloudfoundry", "-f", `{{if and .CgoFiles (not .Standard)}}{{.ImportPath}} {{join .CgoPkgConfig " "}}{{end}}`, "./cmd/api").Return(nil)
+
+				Expect(gf.CheckCgo(finalize.BuildpackConfig{})).To(Succeed())
+			})
+		})
+
+		Context("only standard library packages use cgo", func() {
+			It("leaves them out and does not check for a C toolchain", func() {
+				// go list -deps on an app importing net/http would list
+				// net and runtime/cgo, but the template drops them.
+				expectGoList("")
+
+				Expect(gf.CheckCgo(finalize.BuildpackConfig{})).To(Succeed())
+				Expect(buffer.String()).NotTo(ContainSubstring("Checking cgo requirements"))
+			})
+		})
+
+		Context("packages use cgo", func() {
+			BeforeEach(func() {
+				expectGoList("github.com/mattn/go-sqlite3 sqlite3\ngithub.com/confluentinc/kafka rdkafka sqlite3\n")
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "go", "env", "CC").Do(func(_ string, buffer, _ io.Writer, _ string, _ ...string) {
+					_, err := buffer.Write([]byte("gcc\n"))
+					Expect(err).To(BeNil())
+				}).Return(nil)
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "pkg-config", "--version").Return(nil)
+			})
+
+			It("succeeds when everything is present", func() {
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "gcc", "--version").Return(nil)
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "pkg-config", "--exists", "rdkafka").Return(nil)
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "pkg-config", "--exists", "sqlite3").Return(nil)
+
+				err = gf.CheckCgo(finalize.BuildpackConfig{})
+				Expect(err).To(BeNil())
+
+				Expect(buffer.String()).To(ContainSubstring("Checking cgo requirements for github.com/mattn/go-sqlite3, github.com/confluentinc/kafka"))
+			})
+
+			It("lists everything that is missing", func() {
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "gcc", "--version").Return(errors.New("not found"))
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "pkg-config", "--exists", "rdkafka").Return(errors.New("exit status 1"))
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "pkg-config", "--exists", "sqlite3").Return(nil)
+
+				err = gf.CheckCgo(finalize.BuildpackConfig{})
+				Expect(err).To(MatchError("missing cgo requirements"))
+
+				Expect(buffer.String()).To(ContainSubstring("- C compiler: gcc"))
+				Expect(buffer.String()).To(ContainSubstring("- pkg-config package: rdkafka"))
+				Expect(buffer.String()).NotTo(ContainSubstring("- pkg-config package: sqlite3"))
+			})
+		})
+
+		Context("CGO_ENABLED is 0", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("CGO_ENABLED", "0")).To(Succeed())
+			})
+
+			It("skips the checks", func() {
+				err = gf.CheckCgo(finalize.BuildpackConfig{})
+				Expect(err).To(BeNil())
+			})
+		})
+	})
+
+	Describe("SetCgoDepsEnvironment", func() {
+		var oldCgoCFlags, oldCgoLDFlags, oldPkgConfigPath string
+
+		BeforeEach(func() {
+			oldCgoCFlags = os.Getenv("CGO_CFLAGS")
+			oldCgoLDFlags = os.Getenv("CGO_LDFLAGS")
+			oldPkgConfigPath = os.Getenv("PKG_CONFIG_PATH")
+			Expect(os.Unsetenv("CGO_CFLAGS")).To(Succeed())
+			Expect(os.Unsetenv("CGO_LDFLAGS")).To(Succeed())
+			Expect(os.Setenv("PKG_CONFIG_PATH", "/usr/lib/pkgconfig")).To(Succeed())
+
+			for _, dir := range []string{"00/include", "00/lib/pkgconfig", "03/lib", "07/include"} {
+				Expect(os.MkdirAll(filepath.Join(depsDir, dir), 0755)).To(Succeed())
+			}
+
+			DeferCleanup(func() {
+				Expect(os.Setenv("CGO_CFLAGS", oldCgoCFlags)).To(Succeed())
+				Expect(os.Setenv("CGO_LDFLAGS", oldCgoLDFlags)).To(Succeed())
+				Expect(os.Setenv("PKG_CONFIG_PATH", oldPkgConfigPath)).To(Succeed())
+			})
+		})
+
+		It("sets the cgo environment from earlier deps dirs only", func() {
+			err = gf.SetCgoDepsEnvironment()
+			Expect(err).To(BeNil())
+
+			Expect(os.Getenv("CGO_CFLAGS")).To(Equal("-O2 -g -I" + filepath.Join(depsDir, "00", "include")))
+
+			rel, err := filepath.Rel(filepath.Join(buildDir, "bin"), filepath.Join(depsDir, "00", "lib"))
+			Expect(err).To(BeNil())
+			Expect(os.Getenv("CGO_LDFLAGS")).To(ContainSubstring("-L" + filepath.Join(depsDir, "00", "lib") + " -Wl,-rpath,$ORIGIN/" + rel))
+			Expect(os.Getenv("CGO_LDFLAGS")).To(ContainSubstring("-L" + filepath.Join(depsDir, "03", "lib")))
+			Expect(os.Getenv("CGO_CFLAGS")).NotTo(ContainSubstring(filepath.Join(depsDir, "07")))
+
+			Expect(os.Getenv("PKG_CONFIG_PATH")).To(Equal(filepath.Join(depsDir, "00", "lib", "pkgconfig") + ":/usr/lib/pkgconfig"))
+			Expect(gf.DepsLibDirs).To(Equal([]string{"$DEPS_DIR/00/lib", "$DEPS_DIR/03/lib"}))
+		})
+
+		It("writes LD_LIBRARY_PATH to <depDir>/profile.d", func() {
+			err = gf.SetCgoDepsEnvironment()
+			Expect(err).To(BeNil())
+
+			err = gf.CreateStartupEnvironment()
+			Expect(err).To(BeNil())
+
+			contents, err := os.ReadFile(filepath.Join(gf.Stager.DepDir(), "profile.d", "godepslibs.sh"))
+			Expect(err).To(BeNil())
+			Expect(string(contents)).To(Equal("export LD_LIBRARY_PATH=$DEPS_DIR/00/lib:$DEPS_DIR/03/lib${LD_LIBRARY_PATH:+:$LD_LIBRARY_PATH}\n"))
+		})
+	})
+
+	Describe("SetPGOProfiles", func() {
+		BeforeEach(func() {
+			vendorTool = "gomod"
+			goVersion = "1.22.4"
+			packageList = []string{"./cmd/api", "./cmd/worker"}
+
+			err = os.MkdirAll(filepath.Join(buildDir, "profiles"), 0755)
+			Expect(err).To(BeNil())
+
+			file, err := os.Create(filepath.Join(buildDir, "profiles", "api.pprof"))
+			Expect(err).To(BeNil())
+			defer file.Close()
+
+			p := &profile.Profile{
+				SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+				PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+			}
+			Expect(p.Write(file)).To(Succeed())
+
+			DeferCleanup(func() {
+				packageList = []string{}
+			})
+		})
+
+		It("records the profile for the mapped package", func() {
+			err = gf.SetPGOProfiles(finalize.BuildpackConfig{PGO: map[string]string{"cmd/api": "profiles/api.pprof"}})
+			Expect(err).To(BeNil())
+
+			Expect(gf.PGOProfiles).To(Equal(map[string]string{"./cmd/api": filepath.Join(buildDir, "profiles", "api.pprof")}))
+		})
+
+		Context("the profile does not parse", func() {
+			BeforeEach(func() {
+				err = os.WriteFile(filepath.Join(buildDir, "profiles", "broken.pprof"), []byte("not a profile"), 0644)
+				Expect(err).To(BeNil())
+			})
+
+			It("returns an error", func() {
+				err = gf.SetPGOProfiles(finalize.BuildpackConfig{PGO: map[string]string{"./cmd/api": "profiles/broken.pprof"}})
+				Expect(err).To(MatchError(ContainSubstring("invalid profile profiles/broken.pprof for package ./cmd/api")))
+			})
+		})
+
+		Context("the profile does not exist", func() {
+			It("returns an error", func() {
+				err = gf.SetPGOProfiles(finalize.BuildpackConfig{PGO: map[string]string{"./cmd/api": "profiles/missing.pprof"}})
+				Expect(err).NotTo(BeNil())
+			})
+		})
+
+		Context("the go version does not support PGO", func() {
+			BeforeEach(func() {
+				goVersion = "1.20.1"
+			})
+
+			It("returns an error", func() {
+				err = gf.SetPGOProfiles(finalize.BuildpackConfig{PGO: map[string]string{"./cmd/api": "profiles/api.pprof"}})
+				Expect(err).To(MatchError("go version 1.20.1 does not support profile-guided optimization"))
+			})
+		})
+	})
+
+	Describe("SetRuntimeTuning", func() {
+		It("defaults the memory headroom", func() {
+			gf.SetRuntimeTuning(finalize.RuntimeConfig{})
+			Expect(gf.MemoryHeadroom).To(Equal(10))
+			Expect(gf.RuntimeDefaults).To(BeEmpty())
+		})
+
+		It("keeps only the defaults that are set", func() {
+			headroom := 0
+			gf.SetRuntimeTuning(finalize.RuntimeConfig{MemoryHeadroom: &headroom, GOGC: "200"})
+			Expect(gf.MemoryHeadroom).To(Equal(0))
+			Expect(gf.RuntimeDefaults).To(Equal(map[string]string{"GOGC": "200"}))
+		})
+
+		It("rejects a headroom that is not a percentage", func() {
+			headroom := 100
+			Expect(finalize.RuntimeConfig{MemoryHeadroom: &headroom}.Validate()).To(MatchError("memory_headroom must be a percentage between 0 and 99"))
+		})
+	})
+
+	Describe("InstallDelve", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dlv", "bin"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dlv", "bin", "dlv"), []byte("dlv"), 0755)).To(Succeed())
+		})
+
+		It("does nothing when the debug profile is off", func() {
+			err = gf.InstallDelve(finalize.DebugConfig{})
+			Expect(err).To(BeNil())
+
+			Expect(filepath.Join(buildDir, "bin", "dlv")).NotTo(BeAnExistingFile())
+			Expect(gf.DebugPort).To(Equal(0))
+		})
+
+		It("copies Delve into the droplet and uses the default port", func() {
+			err = gf.InstallDelve(finalize.DebugConfig{Enabled: true})
+			Expect(err).To(BeNil())
+
+			Expect(filepath.Join(buildDir, "bin", "dlv")).To(BeAnExistingFile())
+			Expect(gf.DebugPort).To(Equal(40000))
+		})
+
+		It("builds without optimisations or inlining", func() {
+			gf.SetBuildFlags(finalize.BuildpackConfig{LDFlags: map[string]string{}, Debug: finalize.DebugConfig{Enabled: true}})
+			Expect(gf.BuildFlags).To(Equal([]string{"-tags", "cloudfoundry", "-buildmode", "pie", "-gcflags", "all=-N -l"}))
+		})
+
+		It("cannot be combined with stripping", func() {
+			config := finalize.BuildpackConfig{Debug: finalize.DebugConfig{Enabled: true}, Strip: finalize.StripConfig{Mode: "linker"}}
+			Expect(config.Validate()).To(MatchError("debug builds cannot be stripped"))
+		})
+	})
+
+	Describe("SetProcesses", func() {
+		BeforeEach(func() {
+			mainPackageName = "example.com/a-go-app"
+			Expect(os.MkdirAll(filepath.Join(buildDir, "bin"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "bin", "a-go-app"), []byte("app"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "bin", "worker"), []byte("worker"), 0755)).To(Succeed())
+		})
+
+		It("defaults to a web process running the main binary", func() {
+			Expect(gf.SetProcesses(nil)).To(Succeed())
+			Expect(gf.Processes).To(Equal(map[string]finalize.ProcessConfig{"web": {Binary: "a-go-app"}}))
+		})
+
+		It("resolves and logs the configured processes", func() {
+			Expect(gf.SetProcesses(map[string]finalize.ProcessConfig{
+				"web":    {Args: []string{"--port=$PORT", "--config", "config/app settings.yml"}},
+				"worker": {Binary: "worker", Env: map[string]string{"QUEUE": "jobs; rm -rf /"}},
+			})).To(Succeed())
+
+			Expect(gf.Processes["web"].Binary).To(Equal("a-go-app"))
+			Expect(buffer.String()).To(ContainSubstring(`web: ./bin/a-go-app "--port=$PORT" --config "config/app settings.yml"`))
+			Expect(buffer.String()).To(ContainSubstring(`worker: QUEUE="jobs; rm -rf /" ./bin/worker`))
+		})
+
+		It("returns an error when a process runs a binary that was not built", func() {
+			err = gf.SetProcesses(map[string]finalize.ProcessConfig{"migrate": {Binary: "migrate"}})
+			Expect(err).To(MatchError("process migrate runs bin/migrate, which was not built"))
+		})
+
+		It("rejects invalid process config", func() {
+			config := finalize.BuildpackConfig{Processes: map[string]finalize.ProcessConfig{"web": {Env: map[string]string{"1BAD": "x"}}}}
+			Expect(config.Validate()).To(MatchError(`processes: web: "1BAD" is not a valid environment variable name`))
+
+			config = finalize.BuildpackConfig{Processes: map[string]finalize.ProcessConfig{"web": {Binary: "../app"}}}
+			Expect(config.Validate()).To(MatchError(`processes: web: binary "../app" must be the name of a file in bin/`))
+
+			config = finalize.BuildpackConfig{Processes: map[string]finalize.ProcessConfig{"web server": {}}}
+			Expect(config.Validate()).To(MatchError(`processes: "web server" is not a valid process type`))
+		})
+	})
+
+	Describe("InstallCrashCapture", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "bin"), 0755)).To(Succeed())
+		})
+
+		It("does nothing when crash capture is off", func() {
+			Expect(gf.InstallCrashCapture(finalize.CrashCaptureConfig{})).To(Succeed())
+
+			Expect(filepath.Join(buildDir, "bin", "go-crash-capture")).NotTo(BeAnExistingFile())
+			Expect(gf.CrashCapture).To(BeFalse())
+		})
+
+		It("refuses to overwrite an app binary with the same name", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "bin", "go-crash-capture"), []byte("app"), 0755)).To(Succeed())
+
+			Expect(gf.InstallCrashCapture(finalize.CrashCaptureConfig{Enabled: true})).To(MatchError("the crash capture wrapper would overwrite bin/go-crash-capture"))
+		})
+
+		It("cannot be combined with the debug profile", func() {
+			config := finalize.BuildpackConfig{Debug: finalize.DebugConfig{Enabled: true}, CrashCapture: finalize.CrashCaptureConfig{Enabled: true}}
+			Expect(config.Validate()).To(MatchError("debug builds cannot use crash capture"))
+		})
+
+		Context("the wrapper is installed", func() {
+			var (
+				crashDir string
+				run      func(script string) (string, int)
+			)
+
+			JustBeforeEach(func() {
+				Expect(gf.InstallCrashCapture(finalize.CrashCaptureConfig{Enabled: true})).To(Succeed())
+				Expect(gf.CrashCapture).To(BeTrue())
+
+				crashDir = filepath.Join(buildDir, "crash")
+				run = func(script string) (string, int) {
+					var stderr bytes.Buffer
+					cmd := exec.Command(filepath.Join(buildDir, "bin", "go-crash-capture"), "sh", "-c", script)
+					cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + buildDir}
+					cmd.Stderr = &stderr
+					err := cmd.Run()
+
+					var exitErr *exec.ExitError
+					if errors.As(err, &exitErr) {
+						return stderr.String(), exitErr.ExitCode()
+					}
+					Expect(err).To(BeNil())
+					return stderr.String(), 0
+				}
+			})
+
+			It("saves the panic trace and prints a summary", func() {
+				stderr, status := run(`echo starting >&2; printf 'panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\ngoroutine 6 [select]:\n' >&2; exit 2`)
+
+				Expect(status).To(Equal(2))
+				Expect(stderr).To(ContainSubstring("goroutine 6 [select]:"))
+				Expect(stderr).To(ContainSubstring("CRASH: panic: boom (exit status 2, 2 goroutines, trace saved to " + crashDir + "/panic.log)"))
+
+				contents, err := os.ReadFile(filepath.Join(crashDir, "panic.log"))
+				Expect(err).To(BeNil())
+				Expect(string(contents)).To(HavePrefix("panic: boom\n"))
+				Expect(string(contents)).NotTo(ContainSubstring("starting"))
+			})
+
+			It("passes other exit codes through without a summary", func() {
+				stderr, status := run(`echo "GOTRACEBACK=$GOTRACEBACK" >&2; exit 3`)
+
+				Expect(status).To(Equal(3))
+				Expect(stderr).To(Equal("GOTRACEBACK=all\n"))
+				Expect(crashDir).NotTo(BeAnExistingFile())
+			})
+
+			It("passes stdin through to the binary", func() {
+				var stdout bytes.Buffer
+				cmd := exec.Command(filepath.Join(buildDir, "bin", "go-crash-capture"), "cat")
+				cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + buildDir}
+				cmd.Stdin = bytes.NewBufferString("piped input\n")
+				cmd.Stdout = &stdout
+				Expect(cmd.Run()).To(Succeed())
+
+				Expect(stdout.String()).To(Equal("piped input\n"))
+			})
+		})
+	})
+
+	Describe("PruneBuildDir", func() {
+		BeforeEach(func() {
+			for _, file := range []string{
+				"main.go",
+				"go.mod",
+				"vendor/github.com/lib/lib.go",
+				"testdata/fixture.json",
+				"bin/app",
+				".profile.d/app.sh",
+				".cloudfoundry/0/config.yml",
+				"Procfile",
+				"templates/index.html",
+				"config/app.yml",
+				"config/app_test.yml",
+				"config/notes.txt",
+			} {
+				Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, file)), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, file), []byte("1234"), 0644)).To(Succeed())
+			}
+		})
+
+		It("does nothing unless enabled", func() {
+			err = gf.PruneBuildDir(finalize.PruneConfig{})
+			Expect(err).To(BeNil())
+
+			Expect(filepath.Join(buildDir, "main.go")).To(BeAnExistingFile())
+		})
+
+		It("keeps only what is needed at launch and the listed assets", func() {
+			err = gf.PruneBuildDir(finalize.PruneConfig{Enabled: true, Keep: []string{"templates", "config/*.yml"}})
+			Expect(err).To(BeNil())
+
+			for _, file := range []string{"bin/app", ".profile.d/app.sh", ".cloudfoundry/0/config.yml", "Procfile", "templates/index.html", "config/app.yml", "config/app_test.yml"} {
+				Expect(filepath.Join(buildDir, file)).To(BeAnExistingFile())
+			}
+			for _, file := range []string{"main.go", "go.mod", "vendor", "testdata", "config/notes.txt"} {
+				Expect(filepath.Join(buildDir, file)).NotTo(BeAnExistingFile())
+			}
+
+			Expect(buffer.String()).To(ContainSubstring("Removed 5 files, saving 20 B"))
+		})
+
+		Context("GO_SETUP_GOPATH_IN_IMAGE = true", func() {
+			var oldGoSetupGopathInImage string
+
+			BeforeEach(func() {
+				vendorTool = "go_nativevendoring"
+				mainPackageName = "example.com/app"
+				goPath = buildDir
+
+				oldGoSetupGopathInImage = os.Getenv("GO_SETUP_GOPATH_IN_IMAGE")
+				Expect(os.Setenv("GO_SETUP_GOPATH_IN_IMAGE", "true")).To(Succeed())
+				DeferCleanup(os.Setenv, "GO_SETUP_GOPATH_IN_IMAGE", oldGoSetupGopathInImage)
+
+				for _, file := range []string{"src/example.com/app/main.go", "src/example.com/app/templates/index.html", "pkg/linux_amd64/lib.a"} {
+					Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, file)), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, file), []byte("1234"), 0644)).To(Succeed())
+				}
+			})
+
+			It("matches keep patterns relative to the app inside the GOPATH", func() {
+				err = gf.PruneBuildDir(finalize.PruneConfig{Enabled: true, Keep: []string{"templates"}})
+				Expect(err).To(BeNil())
+
+				Expect(filepath.Join(buildDir, "src", "example.com", "app", "templates", "index.html")).To(BeAnExistingFile())
+				Expect(filepath.Join(buildDir, "src", "example.com", "app", "main.go")).NotTo(BeAnExistingFile())
+				Expect(filepath.Join(buildDir, "pkg")).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("the module is in a subdirectory", func() {
+			BeforeEach(func() {
+				vendorTool = "gomod"
+				moduleDir = filepath.Join("services", "api")
+				DeferCleanup(func() { moduleDir = "" })
+
+				for _, file := range []string{"services/api/main.go", "services/api/go.mod", "services/api/templates/index.html", "shared/lib.go"} {
+					Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, file)), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, file), []byte("1234"), 0644)).To(Succeed())
+				}
+			})
+
+			It("matches keep patterns relative to the module dir and prunes the rest of the tree", func() {
+				err = gf.PruneBuildDir(finalize.PruneConfig{Enabled: true, Keep: []string{"templates"}})
+				Expect(err).To(BeNil())
+
+				Expect(filepath.Join(buildDir, "services", "api", "templates", "index.html")).To(BeAnExistingFile())
+				Expect(filepath.Join(buildDir, "bin", "app")).To(BeAnExistingFile())
+				Expect(filepath.Join(buildDir, "services", "api", "main.go")).NotTo(BeAnExistingFile())
+				Expect(filepath.Join(buildDir, "shared")).NotTo(BeAnExistingFile())
+				Expect(filepath.Join(buildDir, "templates")).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Describe("Validate", func() {
+			It("rejects malformed patterns", func() {
+				Expect(finalize.PruneConfig{Keep: []string{"config/[a"}}.Validate()).To(MatchError(ContainSubstring(`invalid keep pattern "config/[a"`)))
+			})
+
+			It("rejects paths outside the app", func() {
+				Expect(finalize.PruneConfig{Keep: []string{"../shared"}}.Validate()).To(MatchError(`keep pattern "../shared" must be relative to the app`))
+			})
+		})
+	})
+
+	Describe("CheckSensitiveFiles", func() {
+		var oldPolicy, oldPatterns string
+
+		BeforeEach(func() {
+			oldPolicy = os.Getenv("GO_SENSITIVE_FILES_POLICY")
+			oldPatterns = os.Getenv("GO_SENSITIVE_FILES_PATTERNS")
+			Expect(os.Unsetenv("GO_SENSITIVE_FILES_POLICY")).To(Succeed())
+			Expect(os.Unsetenv("GO_SENSITIVE_FILES_PATTERNS")).To(Succeed())
+			DeferCleanup(func() {
+				Expect(os.Setenv("GO_SENSITIVE_FILES_POLICY", oldPolicy)).To(Succeed())
+				Expect(os.Setenv("GO_SENSITIVE_FILES_PATTERNS", oldPatterns)).To(Succeed())
+			})
+
+			for _, file := range []string{
+				"main.go",
+				".env",
+				"certs/server.pem",
+				"home/.aws/credentials",
+				"secrets.json",
+				".cloudfoundry/0/go/src/crypto/testdata/test.pem",
+			} {
+				Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, file)), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, file), []byte("xx"), 0644)).To(Succeed())
+			}
+		})
+
+		It("warns about matching files by default", func() {
+			err = gf.CheckSensitiveFiles(finalize.SensitiveFilesConfig{})
+			Expect(err).To(BeNil())
+
+			Expect(buffer.String()).To(ContainSubstring("**WARNING** The following files look like they contain secrets"))
+			Expect(buffer.String()).To(ContainSubstring("    .env\n"))
+			Expect(buffer.String()).To(ContainSubstring("    certs/server.pem\n"))
+			Expect(buffer.String()).To(ContainSubstring("    home/.aws/credentials\n"))
+			Expect(buffer.String()).NotTo(ContainSubstring("secrets.json"))
+			Expect(buffer.String()).NotTo(ContainSubstring("test.pem"))
+			Expect(filepath.Join(buildDir, ".env")).To(BeAnExistingFile())
+		})
+
+		It("strips matching files, including configured patterns", func() {
+			err = gf.CheckSensitiveFiles(finalize.SensitiveFilesConfig{Policy: "strip", Patterns: []string{"secrets.*"}})
+			Expect(err).To(BeNil())
+
+			Expect(filepath.Join(buildDir, ".env")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(buildDir, "secrets.json")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(buildDir, "main.go")).To(BeAnExistingFile())
+			Expect(buffer.String()).To(ContainSubstring("**WARNING** Removed the following files"))
+		})
+
+		Context("the staging environment defaults to failing", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("GO_SENSITIVE_FILES_POLICY", "fail")).To(Succeed())
+			})
+
+			It("fails even when buildpack.yml asks for a weaker policy", func() {
+				err = gf.CheckSensitiveFiles(finalize.SensitiveFilesConfig{Policy: "warn"})
+				Expect(err).To(MatchError("found 3 sensitive files"))
+
+				Expect(buffer.String()).To(ContainSubstring("**ERROR** Staging stopped because the following files look like they contain secrets"))
+			})
+		})
+
+		Context("the buildpack's manifest requires failing", func() {
+			JustBeforeEach(func() {
+				gf.SensitiveFiles = finalize.SensitiveFilesConfig{Policy: "fail", Patterns: []string{"*.json"}}
+			})
+
+			It("fails even when the app's environment asks for a weaker policy", func() {
+				Expect(os.Setenv("GO_SENSITIVE_FILES_POLICY", "warn")).To(Succeed())
+
+				err = gf.CheckSensitiveFiles(finalize.SensitiveFilesConfig{Policy: "warn"})
+				Expect(err).To(MatchError("found 4 sensitive files"))
+				Expect(buffer.String()).To(ContainSubstring("    secrets.json"))
+			})
+		})
+
+		Describe("LoadOperatorSensitiveFiles", func() {
+			var buildpackDir string
+
+			BeforeEach(func() {
+				buildpackDir, err = os.MkdirTemp("", "go-buildpack.bp.")
+				Expect(err).To(BeNil())
+				DeferCleanup(os.RemoveAll, buildpackDir)
+			})
+
+			It("reads the sensitive_files section of manifest.yml", func() {
+				Expect(os.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), []byte("---\nlanguage: go\nsensitive_files:\n  policy: strip\n  patterns:\n  - '*.json'\n"), 0644)).To(Succeed())
+
+				config, err := finalize.LoadOperatorSensitiveFiles(buildpackDir)
+				Expect(err).To(BeNil())
+				Expect(config).To(Equal(finalize.SensitiveFilesConfig{Policy: "strip", Patterns: []string{"*.json"}}))
+			})
+
+			It("has no policy when the manifest sets none", func() {
+				Expect(os.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), []byte("---\nlanguage: go\n"), 0644)).To(Succeed())
+
+				config, err := finalize.LoadOperatorSensitiveFiles(buildpackDir)
+				Expect(err).To(BeNil())
+				Expect(config).To(Equal(finalize.SensitiveFilesConfig{}))
+			})
+		})
+
+		Context("the operator adds patterns", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("GO_SENSITIVE_FILES_PATTERNS", "*.json")).To(Succeed())
+			})
+
+			It("includes them in the scan", func() {
+				err = gf.CheckSensitiveFiles(finalize.SensitiveFilesConfig{})
+				Expect(err).To(BeNil())
+
+				Expect(buffer.String()).To(ContainSubstring("    secrets.json"))
+			})
+		})
+
+		It("rejects unknown policies", func() {
+			err = gf.CheckSensitiveFiles(finalize.SensitiveFilesConfig{Policy: "ignore"})
+			Expect(err).To(MatchError(ContainSubstring(`unknown sensitive files policy "ignore"`)))
+		})
+	})
+
+	Describe("CreateStartupEnvironment", func() {
+		BeforeEach(func() {
+			goVersion = "3.4.5"
+			mainPackageName = "a-go-app"
+			goPath = buildDir
+
+			goDir := filepath.Join(depsDir, depsIdx, "go"+goVersion, "go")
+			err = os.MkdirAll(goDir, 0755)
+			Expect(err).To(BeNil())
+		})
+
+		It("writes the buildpack-release-step.yml file to <depDir>", func() {
+			err = gf.CreateStartupEnvironment()
+			Expect(err).To(BeNil())
+
+			contents, err := os.ReadFile(filepath.Join(depsDir, depsIdx, "buildpack-release-step.yml"))
+			Expect(err).To(BeNil())
+
+			yaml := `---
+default_process_types:
+    web: ./bin/a-go-app
+`
+			Expect(string(contents)).To(Equal(yaml))
+		})
+
+		Context("the Go runtime is tuned for the container", func() {
+			var runScript func(env ...string) string
+
+			JustBeforeEach(func() {
+				headroom := 25
+				gf.SetRuntimeTuning(finalize.RuntimeConfig{MemoryHeadroom: &headroom, GOTRACEBACK: "crash", GODEBUG: "madvdontneed=1,gctrace=0"})
+				Expect(gf.CreateStartupEnvironment()).To(Succeed())
+
+				runScript = func(env ...string) string {
+					script := filepath.Join(gf.Stager.DepDir(), "profile.d", "goruntime.sh")
+					cmd := exec.Command("sh", "-c", `. "$0"; echo "$GOMEMLIMIT|$GOTRACEBACK|$GODEBUG"`, script)
+					cmd.Env = append([]string{"PATH=" + os.Getenv("PATH")}, env...)
+					output, err := cmd.Output()
+					Expect(err).To(BeNil())
+					return string(output)
+				}
+			})
+
+			It("logs the tuning", func() {
+				Expect(buffer.String()).To(ContainSubstring("-----> Setting GOMEMLIMIT at launch to the container memory limit less 25% headroom"))
+				Expect(buffer.String()).To(ContainSubstring("Defaulting GOTRACEBACK=crash"))
+			})
+
+			It("derives GOMEMLIMIT from MEMORY_LIMIT and applies the defaults", func() {
+				Expect(runScript("MEMORY_LIMIT=1024m")).To(Equal("805306350|crash|madvdontneed=1,gctrace=0\n"))
+			})
+
+			It("keeps values the app sets itself", func() {
+				Expect(runScript("MEMORY_LIMIT=1g", "GOMEMLIMIT=off", "GOTRACEBACK=single")).To(Equal("off|single|madvdontneed=1,gctrace=0\n"))
+			})
+		})
+
+		Context("packages are built with coverage", func() {
+			JustBeforeEach(func() {
+				gf.CoverPackages = map[string]bool{"./cmd/api": true}
+				gf.CoverDir = "$TMPDIR/gocoverdir"
+			})
+
+			It("writes GOCOVERDIR to <depDir>/profile.d", func() {
+				err = gf.CreateStartupEnvironment()
+				Expect(err).To(BeNil())
+
+				contents, err := os.ReadFile(filepath.Join(gf.Stager.DepDir(), "profile.d", "gocover.sh"))
+				Expect(err).To(BeNil())
+				Expect(string(contents)).To(Equal("export GOCOVERDIR=${GOCOVERDIR:-$TMPDIR/gocoverdir}\nmkdir -p \"$GOCOVERDIR\"\n"))
+			})
+		})
+
+		Context("the debug profile is on", func() {
+			JustBeforeEach(func() {
+				gf.DebugPort = 2345
+			})
+
+			It("starts the app under Delve", func() {
+				err = gf.CreateStartupEnvironment()
+				Expect(err).To(BeNil())
+
+				contents, err := os.ReadFile(filepath.Join(depsDir, depsIdx, "buildpack-release-step.yml"))
+				Expect(err).To(BeNil())
+
+				yaml := `---
+default_process_types:
+    web: ./bin/dlv exec ./bin/a-go-app --headless --listen=:${DLV_PORT:-2345} --api-version=2 --accept-multiclient --continue
+`
+				Expect(string(contents)).To(Equal(yaml))
+			})
+		})
+
+		Context("buildpack.yml configures processes", func() {
+			JustBeforeEach(func() {
+				gf.DebugPort = 2345
+				gf.Processes = map[string]finalize.ProcessConfig{
+					"web":    {Binary: "a-go-app", Args: []string{"--port=$PORT", "--name", "it's `here`: #1"}},
+					"worker": {Binary: "worker", Args: []string{"--queue", "jobs"}, Env: map[string]string{"LOG_LEVEL": "debug", "PRICE": "$(cost) \\o/"}},
+				}
+			})
+
+			It("quotes the arguments and environment into each start command", func() {
+				err = gf.CreateStartupEnvironment()
+				Expect(err).To(BeNil())
+
+				contents, err := os.ReadFile(filepath.Join(depsDir, depsIdx, "buildpack-release-step.yml"))
+				Expect(err).To(BeNil())
+
+				yaml := `---
+default_process_types:
+    web: './bin/dlv exec ./bin/a-go-app --headless --listen=:${DLV_PORT:-2345} --api-version=2 --accept-multiclient --continue -- "--port=$PORT" --name "it''s \` + "`" + `here\` + "`" + `: #1"'
+    worker: LOG_LEVEL=debug PRICE="\$(cost) \\o/" ./bin/worker --queue jobs
+`
+				Expect(string(contents)).To(Equal(yaml))
+			})
+		})
+
+		Context("the binary name has a space", func() {
+			JustBeforeEach(func() {
+				gf.Processes = map[string]finalize.ProcessConfig{"web": {Binary: "my tool", Args: []string{"--port=$PORT"}}}
+			})
+
+			It("quotes the binary path", func() {
+				Expect(gf.CreateStartupEnvironment()).To(Succeed())
+
+				contents, err := os.ReadFile(filepath.Join(depsDir, depsIdx, "buildpack-release-step.yml"))
+				Expect(err).To(BeNil())
+
+				yaml := `---
+default_process_types:
+    web: '"./bin/my tool" "--port=$PORT"'
+`
+				Expect(string(contents)).To(Equal(yaml))
+			})
+		})
+
+		Context("crash capture is on", func() {
+			JustBeforeEach(func() {
+				gf.CrashCapture = true
+			})
+
+			It("starts the app through the wrapper", func() {
+				err = gf.CreateStartupEnvironment()
+				Expect(err).To(BeNil())
+
+				contents, err := os.ReadFile(filepath.Join(depsDir, depsIdx, "buildpack-release-step.yml"))
+				Expect(err).To(BeNil())
+
+				yaml := `---
+default_process_types:
+    web: ./bin/go-crash-capture ./bin/a-go-app
+`
+				Expect(string(contents)).To(Equal(yaml))
+			})
+		})
+
+		It("writes the go.sh script to <depDir>/profile.d", func() {
+			err = gf.CreateStartupEnvironment()
+			Expect(err).To(BeNil())
+
+			contents, err := os.ReadFile(filepath.Join(gf.Stager.DepDir(), "profile.d", "go.sh"))
+			Expect(err).To(BeNil())
+
+			Expect(string(contents)).To(Equal("PATH=$PATH:$HOME/bin\n"))
+		})
+
+		Context("the app requires CPU features", func() {
+			JustBeforeEach(func() {
+				gf.MicroarchLevel = "GOAMD64=v2"
+				gf.RequiredCPUFeatures = []string{"popcnt", "sse4_2"}
+			})
+
+			It("writes a launch check to <depDir>/profile.d", func() {
+				err = gf.CreateStartupEnvironment()
+				Expect(err).To(BeNil())
+
+				contents, err := os.ReadFile(filepath.Join(gf.Stager.DepDir(), "profile.d", "gomicroarch.sh"))
+				Expect(err).To(BeNil())
+
+				Expect(string(contents)).To(ContainSubstring("for feature in popcnt sse4_2; do"))
+				Expect(string(contents)).To(ContainSubstring("built with GOAMD64=v2"))
+			})
 		})
 
 		Context("GO_INSTALL_TOOLS_IN_IMAGE is not set", func() {
@@ -1116,7 +2794,7 @@ default_process_types:
 			})
 
 			It("clears the dep dir", func() {
-				err = gf.CreateStartupEnvironment(tempDir)
+				err = gf.CreateStartupEnvironment()
 				Expect(err).To(BeNil())
 
 				Expect(filepath.Join(depsDir, "06", "go3.4.5")).NotTo(BeADirectory())
@@ -1141,14 +2819,14 @@ default_process_types:
 			})
 
 			It("does not remove the go toolchain", func() {
-				err = gf.CreateStartupEnvironment(tempDir)
+				err = gf.CreateStartupEnvironment()
 				Expect(err).To(BeNil())
 
 				Expect(filepath.Join(depsDir, "06", "go3.4.5")).To(BeADirectory())
 			})
 
 			It("logs that the tool chain was copied", func() {
-				err = gf.CreateStartupEnvironment(tempDir)
+				err = gf.CreateStartupEnvironment()
 				Expect(err).To(BeNil())
 
 				Expect(buffer.String()).To(ContainSubstring("-----> Leaving go tool chain in $GOROOT=$DEPS_DIR/06/go3.4.5/go"))
@@ -1175,7 +2853,7 @@ default_process_types:
 			})
 
 			It("cleans up the pkg directory", func() {
-				err = gf.CreateStartupEnvironment(tempDir)
+				err = gf.CreateStartupEnvironment()
 				Expect(err).To(BeNil())
 
 				Expect(buffer.String()).To(ContainSubstring("-----> Cleaning up $GOPATH/pkg"))
@@ -1183,7 +2861,7 @@ default_process_types:
 			})
 
 			It("writes the zzgopath.sh script to <depDir>/profile.d", func() {
-				err = gf.CreateStartupEnvironment(tempDir)
+				err = gf.CreateStartupEnvironment()
 				Expect(err).To(BeNil())
 
 				contents, err := os.ReadFile(filepath.Join(gf.Stager.DepDir(), "profile.d", "zzgopath.sh"))
@@ -1195,6 +2873,182 @@ default_process_types:
 		})
 	})
 
+	Describe("SetCoverage", func() {
+		BeforeEach(func() {
+			goVersion = "1.22.4"
+			packageList = []string{"./cmd/api", "./cmd/worker"}
+			DeferCleanup(func() {
+				packageList = []string{}
+			})
+		})
+
+		It("records the packages to instrument and the default GOCOVERDIR", func() {
+			err = gf.SetCoverage(finalize.CoverConfig{Packages: []string{"cmd/api"}, CoverPkg: []string{"./internal/..."}})
+			Expect(err).To(BeNil())
+
+			Expect(gf.CoverPackages).To(Equal(map[string]bool{"./cmd/api": true}))
+			Expect(gf.CoverPkg).To(Equal([]string{"./internal/..."}))
+			Expect(gf.CoverDir).To(Equal("$TMPDIR/gocoverdir"))
+			Expect(buffer.String()).To(ContainSubstring("Collect it with: cf ssh <app>"))
+		})
+
+		It("warns about packages that are not being installed", func() {
+			err = gf.SetCoverage(finalize.CoverConfig{Packages: []string{"./cmd/other"}})
+			Expect(err).To(BeNil())
+
+			Expect(gf.CoverPackages).To(BeEmpty())
+			Expect(buffer.String()).To(ContainSubstring("**WARNING** Package ./cmd/other is listed for coverage, but is not being installed"))
+		})
+
+		Context("the go version is too old", func() {
+			BeforeEach(func() {
+				goVersion = "1.19.13"
+			})
+
+			It("returns an error", func() {
+				err = gf.SetCoverage(finalize.CoverConfig{Packages: []string{"./cmd/api"}})
+				Expect(err).To(MatchError("go version 1.19.13 cannot build coverage-instrumented binaries"))
+			})
+		})
+	})
+
+	Describe("RunVet", func() {
+		BeforeEach(func() {
+			vendorTool = "gomod"
+			buildFlags = []string{"-tags", "cloudfoundry", "-buildmode", "pie"}
+			DeferCleanup(func() {
+				buildFlags = []string{}
+			})
+		})
+
+		It("does nothing when no packages are configured", func() {
+			err = gf.RunVet(finalize.BuildpackConfig{})
+			Expect(err).To(BeNil())
+		})
+
+		It("runs go vet with the build tags on the configured packages", func() {
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "go", "vet", "-tags", "cloudfoundry", "./...").Return(nil)
+
+			err = gf.RunVet(finalize.BuildpackConfig{Vet: []string{"./..."}})
+			Expect(err).To(BeNil())
+
+			Expect(buffer.String()).To(ContainSubstring("-----> Running: go vet -tags cloudfoundry ./..."))
+		})
+
+		Context("the app relies on the Godeps workspace", func() {
+			BeforeEach(func() {
+				vendorTool = "godep"
+				godepConfig = godep.Godep{ImportPath: "go-online", WorkspaceExists: true}
+				mainPackageName = "go-online"
+				goPath = filepath.Join(buildDir, "gopath")
+
+				DeferCleanup(func() {
+					mainPackageName = ""
+					goPath = ""
+				})
+			})
+
+			It("runs go vet through godep, as the build does", func() {
+				mockCommand.EXPECT().Execute(filepath.Join(goPath, "src", "go-online"), gomock.Any(), gomock.Any(), "godep", "go", "vet", "-tags", "cloudfoundry", "./...").Return(nil)
+
+				Expect(gf.RunVet(finalize.BuildpackConfig{Vet: []string{"./..."}})).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("-----> Running: godep go vet -tags cloudfoundry ./..."))
+			})
+		})
+	})
+
+	Describe("RunTests", func() {
+		BeforeEach(func() {
+			vendorTool = "gomod"
+			buildFlags = []string{"-tags", "cloudfoundry", "-buildmode", "pie"}
+			DeferCleanup(func() {
+				buildFlags = []string{}
+			})
+		})
+
+		It("does nothing when no packages are configured", func() {
+			err = gf.RunTests(finalize.BuildpackConfig{})
+			Expect(err).To(BeNil())
+		})
+
+		Context("the app relies on the Godeps workspace", func() {
+			BeforeEach(func() {
+				vendorTool = "godep"
+				godepConfig = godep.Godep{ImportPath: "go-online", WorkspaceExists: true}
+				mainPackageName = "go-online"
+				goPath = filepath.Join(buildDir, "gopath")
+
+				DeferCleanup(func() {
+					mainPackageName = ""
+					goPath = ""
+				})
+			})
+
+			It("runs go test through godep, as the build does", func() {
+				mockCommand.EXPECT().Execute(filepath.Join(goPath, "src", "go-online"), gomock.Any(), gomock.Any(), "godep", "go", "test", "-json", "-tags", "cloudfoundry", "-buildmode", "pie", "./...").Return(nil)
+
+				Expect(gf.RunTests(finalize.BuildpackConfig{Test: []string{"./..."}})).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("-----> Running: godep go test -json"))
+			})
+		})
+
+		Context("the tests pass", func() {
+			It("runs go test with the build flags and logs a summary", func() {
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "go", "test", "-json", "-tags", "cloudfoundry", "-buildmode", "pie", "./...").Do(func(_ string, buffer, _ io.Writer, _ string, _ ...string) {
+					_, err := buffer.Write([]byte(`{"Action":"run","Package":"app/pkg","Test":"TestA"}
+{"Action":"pass","Package":"app/pkg","Test":"TestA"}
+{"Action":"skip","Package":"app/pkg","Test":"TestB"}
+{"Action":"pass","Package":"app/pkg"}
+`))
+					Expect(err).To(BeNil())
+				}).Return(nil)
+
+				err = gf.RunTests(finalize.BuildpackConfig{Test: []string{"./..."}})
+				Expect(err).To(BeNil())
+
+				Expect(buffer.String()).To(ContainSubstring("1 passed, 0 failed, 1 skipped"))
+			})
+		})
+
+		Context("a test fails", func() {
+			It("returns an error and summarises the failure", func() {
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "go", "test", "-json", "-tags", "cloudfoundry", "-buildmode", "pie", "./...").Do(func(_ string, buffer, _ io.Writer, _ string, _ ...string) {
+					_, err := buffer.Write([]byte(`{"Action":"run","Package":"app/pkg","Test":"TestA"}
+{"Action":"output","Package":"app/pkg","Test":"TestA","Output":"    a_test.go:12: expected 1, got 2\n"}
+{"Action":"fail","Package":"app/pkg","Test":"TestA"}
+{"Action":"fail","Package":"app/pkg"}
+`))
+					Expect(err).To(BeNil())
+				}).Return(errors.New("exit status 1"))
+
+				err = gf.RunTests(finalize.BuildpackConfig{Test: []string{"./..."}})
+				Expect(err).NotTo(BeNil())
+
+				Expect(buffer.String()).To(ContainSubstring("0 passed, 1 failed, 0 skipped"))
+				Expect(buffer.String()).To(ContainSubstring("**ERROR** Test app/pkg TestA failed"))
+				Expect(buffer.String()).To(ContainSubstring("a_test.go:12: expected 1, got 2"))
+				Expect(buffer.String()).NotTo(ContainSubstring("Package app/pkg failed"))
+			})
+		})
+
+		Context("a package fails to build", func() {
+			It("returns an error and names the package", func() {
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "go", "test", "-json", "-tags", "cloudfoundry", "-buildmode", "pie", "./...").Do(func(_ string, buffer, _ io.Writer, _ string, _ ...string) {
+					_, err := buffer.Write([]byte(`{"ImportPath":"app/broken [app/broken.test]","Action":"build-output","Output":"broken.go:3:1: syntax error\n"}
+{"Action":"fail","Package":"app/broken"}
+`))
+					Expect(err).To(BeNil())
+				}).Return(errors.New("exit status 1"))
+
+				err = gf.RunTests(finalize.BuildpackConfig{Test: []string{"./..."}})
+				Expect(err).NotTo(BeNil())
+
+				Expect(buffer.String()).To(ContainSubstring("**ERROR** Package app/broken failed"))
+				Expect(buffer.String()).To(ContainSubstring("broken.go:3:1: syntax error"))
+			})
+		})
+	})
+
 	Describe("SetGoCache", func() {
 		var currentVar string
 		BeforeEach(func() {
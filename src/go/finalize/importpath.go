@@ -0,0 +1,268 @@
+package finalize
+
+import (
+	"bufio"
+	"encoding/json"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+var (
+	importComment = regexp.MustCompile(`^package\s+\w+\s*//\s*import\s+"([^"]+)"`)
+	tomlTable     = regexp.MustCompile(`^\[\s*([A-Za-z0-9_.-]+)\s*\]`)
+	tomlName      = regexp.MustCompile(`^name\s*=\s*("(?:[^"\\]|\\.)*")`)
+)
+
+// inferImportPath works out the import path of a GOPATH-mode app from files
+// it already has: the name in Gopkg.toml's [metadata] table, a canonical
+// import comment, the rootPath in vendor/vendor.json, or the import path of
+// one of its own sub-packages. It returns the import path and where it was
+// found, or "" when none of them names it.
+func (gf *Finalizer) inferImportPath() (string, string, error) {
+	buildDir := gf.Stager.BuildDir()
+
+	if name, err := gopkgMetadataName(filepath.Join(buildDir, "Gopkg.toml")); err != nil {
+		return "", "", err
+	} else if name != "" {
+		return name, "the [metadata] name in Gopkg.toml", nil
+	}
+
+	if importPath, file, err := importPathFromComments(buildDir); err != nil {
+		return "", "", err
+	} else if importPath != "" {
+		return importPath, "the import comment in " + file, nil
+	}
+
+	if rootPath, err := vendorJSONRootPath(filepath.Join(buildDir, "vendor", "vendor.json")); err != nil {
+		return "", "", err
+	} else if rootPath != "" {
+		return rootPath, "the rootPath in vendor/vendor.json", nil
+	}
+
+	if importPath, file, err := importPathFromSubPackages(buildDir); err != nil {
+		return "", "", err
+	} else if importPath != "" {
+		return importPath, "an import of one of the app's own packages in " + file, nil
+	}
+
+	return "", "", nil
+}
+
+// gopkgMetadataName reads name from the [metadata] table of Gopkg.toml.
+func gopkgMetadataName(file string) (string, error) {
+	contents, err := readIfExists(file)
+	if err != nil || contents == nil {
+		return "", err
+	}
+
+	table := ""
+	scanner := bufio.NewScanner(strings.NewReader(string(contents)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if match := tomlTable.FindStringSubmatch(line); match != nil {
+			table = match[1]
+			continue
+		}
+		if table != "metadata" {
+			continue
+		}
+		if match := tomlName.FindStringSubmatch(line); match != nil {
+			name, err := strconv.Unquote(match[1])
+			if err != nil {
+				return "", nil
+			}
+			return strings.TrimSpace(name), nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+// vendorJSONRootPath reads rootPath from a govendor vendor.json.
+func vendorJSONRootPath(file string) (string, error) {
+	contents, err := readIfExists(file)
+	if err != nil || contents == nil {
+		return "", err
+	}
+
+	var vendorJSON struct {
+		RootPath string `json:"rootPath"`
+	}
+	if err := json.Unmarshal(contents, &vendorJSON); err != nil {
+		return "", nil
+	}
+
+	return strings.TrimSpace(vendorJSON.RootPath), nil
+}
+
+// importPathFromComments derives the app's import path from the shallowest
+// canonical import comment, less the directory of the package declaring it.
+func importPathFromComments(appDir string) (string, string, error) {
+	var (
+		importPath string
+		found      string
+		depth      int
+	)
+
+	err := walkAppSources(appDir, func(rel string, file string) error {
+		dir := path.Dir(rel)
+		fileDepth := strings.Count(rel, "/")
+		if found != "" && fileDepth >= depth {
+			return nil
+		}
+
+		comment, err := packageImportComment(file)
+		if err != nil || comment == "" {
+			return err
+		}
+
+		root := comment
+		if dir != "." {
+			if !strings.HasSuffix(comment, "/"+dir) {
+				return nil
+			}
+			root = strings.TrimSuffix(comment, "/"+dir)
+		}
+
+		importPath, found, depth = root, rel, fileDepth
+		return nil
+	})
+
+	return importPath, found, err
+}
+
+func packageImportComment(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "package ") {
+			if match := importComment.FindStringSubmatch(line); match != nil {
+				return match[1], nil
+			}
+			return "", nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+// importPathFromSubPackages looks for imports whose path ends in the
+// directory of one of the app's own packages, and takes what precedes that
+// directory as the app's import path. Only roots whose first element looks
+// like a host are accepted, so that a package dir such as json does not turn
+// an import of encoding/json into an import path of encoding. Imports that
+// resolve under vendor/ belong to dependencies and are skipped, and every
+// remaining import must give the same root, or none is used.
+func importPathFromSubPackages(appDir string) (string, string, error) {
+	packageDirs := map[string]bool{}
+	err := walkAppSources(appDir, func(rel string, _ string) error {
+		if dir := path.Dir(rel); dir != "." {
+			packageDirs[dir] = true
+		}
+		return nil
+	})
+	if err != nil || len(packageDirs) == 0 {
+		return "", "", err
+	}
+
+	var importPath, found string
+	ambiguous := false
+	err = walkAppSources(appDir, func(rel string, file string) error {
+		parsed, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ImportsOnly)
+		if err != nil {
+			return nil
+		}
+
+		for _, spec := range parsed.Imports {
+			imported, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+
+			longest := ""
+			for dir := range packageDirs {
+				if strings.HasSuffix(imported, "/"+dir) && len(dir) > len(longest) {
+					longest = dir
+				}
+			}
+			if longest == "" {
+				continue
+			}
+
+			root := strings.TrimSuffix(imported, "/"+longest)
+			if host, _, _ := strings.Cut(root, "/"); !strings.Contains(host, ".") {
+				continue
+			}
+
+			if vendored, err := libbuildpack.FileExists(filepath.Join(appDir, "vendor", filepath.FromSlash(imported))); err != nil {
+				return err
+			} else if vendored {
+				continue
+			}
+
+			if importPath == "" {
+				importPath, found = root, rel
+			} else if root != importPath {
+				ambiguous = true
+			}
+		}
+		return nil
+	})
+	if err != nil || ambiguous {
+		return "", "", err
+	}
+
+	return importPath, found, nil
+}
+
+// walkAppSources calls fn with the slash-separated path, relative to appDir,
+// and the full path of every non-test Go file of the app, skipping vendored,
+// hidden and testdata directories.
+func walkAppSources(appDir string, fn func(rel string, file string) error) error {
+	return filepath.WalkDir(appDir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name := entry.Name()
+		if entry.IsDir() {
+			if file != appDir && (name == "vendor" || name == "testdata" || name == "Godeps" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(appDir, file)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), file)
+	})
+}
+
+func readIfExists(file string) ([]byte, error) {
+	exists, err := libbuildpack.FileExists(file)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return os.ReadFile(file)
+}
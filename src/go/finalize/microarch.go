@@ -0,0 +1,110 @@
+package finalize
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// defaultMicroarchLevels holds the microarchitecture level each stack
+// guarantees, keyed by stack and then by GOARCH. Stacks that are not listed
+// use the Go toolchain's baseline.
+var defaultMicroarchLevels = map[string]map[string]string{
+	"cflinuxfs3": {"amd64": "v1", "arm64": "v8.0"},
+	"cflinuxfs4": {"amd64": "v1", "arm64": "v8.0"},
+	"cflinuxfs5": {"amd64": "v1", "arm64": "v8.0"},
+}
+
+// amd64Features lists the /proc/cpuinfo flags each GOAMD64 level adds over
+// the previous one.
+var amd64Features = map[string][]string{
+	"v2": {"cx16", "lahf_lm", "popcnt", "sse4_1", "sse4_2", "ssse3"},
+	"v3": {"avx", "avx2", "bmi1", "bmi2", "f16c", "fma", "abm", "movbe", "xsave"},
+	"v4": {"avx512f", "avx512bw", "avx512cd", "avx512dq", "avx512vl"},
+}
+
+var goarm64Pattern = regexp.MustCompile(`^v(8\.[0-9]|9\.[0-5])((,lse|,crypto)*)$`)
+
+// SetMicroarchitecture selects GOAMD64 or GOARM64 for the build from
+// buildpack.yml, then the staging environment (where operators can set it
+// through the staging environment variable group), then the stack default.
+func (gf *Finalizer) SetMicroarchitecture(config BuildpackConfig) error {
+	arch := os.Getenv("GOARCH")
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+
+	var envVar, level string
+	switch arch {
+	case "amd64":
+		envVar, level = "GOAMD64", config.GOAMD64
+	case "arm64":
+		envVar, level = "GOARM64", config.GOARM64
+	default:
+		return nil
+	}
+
+	source := "buildpack.yml"
+	if level == "" {
+		level, source = os.Getenv(envVar), "environment"
+	}
+	if level == "" {
+		level, source = defaultMicroarchLevels[os.Getenv("CF_STACK")][arch], "stack"
+	}
+	if level == "" {
+		return nil
+	}
+
+	features, err := requiredCPUFeatures(arch, level)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Setenv(envVar, level); err != nil {
+		return err
+	}
+
+	gf.Log.BeginStep("Targeting %s=%s (from %s)", envVar, level, source)
+	gf.MicroarchLevel = fmt.Sprintf("%s=%s", envVar, level)
+	gf.RequiredCPUFeatures = features
+
+	return nil
+}
+
+func requiredCPUFeatures(arch, level string) ([]string, error) {
+	var features []string
+
+	switch arch {
+	case "amd64":
+		levels := []string{"v1", "v2", "v3", "v4"}
+		index := -1
+		for i, l := range levels {
+			if l == level {
+				index = i
+			}
+		}
+		if index == -1 {
+			return nil, fmt.Errorf("invalid GOAMD64 level %q, must be one of %s", level, strings.Join(levels, ", "))
+		}
+
+		for _, l := range levels[1 : index+1] {
+			features = append(features, amd64Features[l]...)
+		}
+	case "arm64":
+		match := goarm64Pattern.FindStringSubmatch(level)
+		if match == nil {
+			return nil, fmt.Errorf("invalid GOARM64 level %q, must look like v8.0 or v9.2,lse", level)
+		}
+
+		if match[1] != "8.0" || strings.Contains(match[2], ",lse") {
+			features = append(features, "atomics")
+		}
+		if strings.Contains(match[2], ",crypto") {
+			features = append(features, "aes", "pmull", "sha1", "sha2")
+		}
+	}
+
+	return features, nil
+}
@@ -132,6 +132,20 @@ func (mr *MockStagerMockRecorder) DepDir() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DepDir", reflect.TypeOf((*MockStager)(nil).DepDir))
 }
 
+// DepsDir mocks base method.
+func (m *MockStager) DepsDir() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DepsDir")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// DepsDir indicates an expected call of DepsDir.
+func (mr *MockStagerMockRecorder) DepsDir() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DepsDir", reflect.TypeOf((*MockStager)(nil).DepsDir))
+}
+
 // DepsIdx mocks base method.
 func (m *MockStager) DepsIdx() string {
 	m.ctrl.T.Helper()
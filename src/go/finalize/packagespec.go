@@ -0,0 +1,151 @@
+package finalize
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// parsePackageSpec splits a package spec into patterns the way a shell splits
+// words: on whitespace, with single quotes, double quotes and backslashes
+// keeping spaces inside a pattern.
+func parsePackageSpec(spec string) ([]string, error) {
+	var (
+		patterns []string
+		current  strings.Builder
+		inWord   bool
+		quote    rune
+		escaped  bool
+	)
+
+	for _, r := range spec {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else if r == '\\' {
+				escaped = true
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inWord = true
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				patterns = append(patterns, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in package spec", quote)
+	}
+	if escaped {
+		return nil, errors.New("package spec ends with a backslash")
+	}
+	if inWord {
+		patterns = append(patterns, current.String())
+	}
+
+	return patterns, nil
+}
+
+// splitExclusions separates the patterns prefixed with ! from the rest.
+func splitExclusions(patterns []string) ([]string, []string) {
+	var included, excluded []string
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			excluded = append(excluded, strings.TrimPrefix(pattern, "!"))
+		} else {
+			included = append(included, pattern)
+		}
+	}
+	return included, excluded
+}
+
+// resolvePackageSpec turns the patterns of a package spec into the packages
+// to install, less those named by exclusions. An exclusion that removes
+// nothing is an error rather than being silently ignored.
+func (gf *Finalizer) resolvePackageSpec(patterns []string) ([]string, error) {
+	included, excluded := splitExclusions(patterns)
+	if len(included) == 0 {
+		return nil, errors.New("package spec only excludes packages")
+	}
+
+	packages, err := gf.expandPackageSpec(included)
+	if err != nil {
+		return nil, err
+	}
+	if len(packages) == 0 {
+		return nil, errors.New("package spec matches no main packages")
+	}
+
+	for _, pattern := range excluded {
+		excludedPackages, err := gf.expandPackageSpec([]string{pattern})
+		if err != nil {
+			return nil, err
+		}
+
+		remaining := slices.DeleteFunc(slices.Clone(packages), func(pkg string) bool {
+			return slices.Contains(excludedPackages, pkg)
+		})
+		if len(remaining) == len(packages) {
+			return nil, fmt.Errorf("package spec exclusion !%s matches none of the packages to install", pattern)
+		}
+		packages = remaining
+	}
+
+	if len(packages) == 0 {
+		return nil, errors.New("package spec excludes every package it names")
+	}
+
+	return packages, nil
+}
+
+// expandPackageSpec expands patterns through go list to the main packages
+// they match. Vendor tools other than go modules and the GOPATH layout only
+// expand ... patterns that way: a plain pattern is kept as it is, so that a
+// vendored package can still be named by its import path.
+func (gf *Finalizer) expandPackageSpec(patterns []string) ([]string, error) {
+	if gf.VendorTool == "gomod" || gf.VendorTool == "gopath" {
+		return gf.listMainPackages(nil, patterns...)
+	}
+
+	var packages []string
+	for _, pattern := range patterns {
+		expanded := []string{pattern}
+		if strings.Contains(pattern, "...") {
+			var err error
+			if expanded, err = gf.listMainPackages(nil, pattern); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, pkg := range expanded {
+			if !slices.Contains(packages, pkg) {
+				packages = append(packages, pkg)
+			}
+		}
+	}
+
+	return packages, nil
+}
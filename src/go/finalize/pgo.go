@@ -0,0 +1,68 @@
+package finalize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Masterminds/semver"
+	"github.com/google/pprof/profile"
+)
+
+// SetPGOProfiles validates the profiles mapped to packages in buildpack.yml
+// and records them so CompileApp can build each of those packages with -pgo.
+// Profile paths are relative to the application root.
+func (gf *Finalizer) SetPGOProfiles(config BuildpackConfig) error {
+	gf.PGOProfiles = map[string]string{}
+
+	if len(config.PGO) == 0 {
+		return nil
+	}
+
+	ver, err := semver.NewVersion(gf.GoVersion)
+	if err != nil {
+		return err
+	}
+	if ver.LessThan(semver.MustParse("1.21.0")) {
+		return fmt.Errorf("go version %s does not support profile-guided optimization", gf.GoVersion)
+	}
+
+	var packages []string
+	for pkg := range config.PGO {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+
+	for _, pkg := range packages {
+		profilePath := filepath.Join(gf.mainPackagePath(), config.PGO[pkg])
+
+		if err := validateProfile(profilePath); err != nil {
+			return fmt.Errorf("invalid profile %s for package %s: %s", config.PGO[pkg], pkg, err)
+		}
+
+		matched := false
+		for _, installPkg := range gf.PackageList {
+			if filepath.Clean(installPkg) == filepath.Clean(pkg) {
+				gf.PGOProfiles[installPkg] = profilePath
+				matched = true
+			}
+		}
+		if !matched {
+			gf.Log.Warning("Profile %s is mapped to package %s, which is not being installed", config.PGO[pkg], pkg)
+		}
+	}
+
+	return nil
+}
+
+func validateProfile(profilePath string) error {
+	file, err := os.Open(profilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = profile.Parse(file)
+	return err
+}
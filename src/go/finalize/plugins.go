@@ -0,0 +1,79 @@
+package finalize
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type PluginConfig struct {
+	Package string `yaml:"package"`
+	// Output is the path of the shared object, relative to the app root.
+	Output string `yaml:"output"`
+}
+
+func (c PluginConfig) Validate() error {
+	if c.Package == "" {
+		return errors.New("every plugin needs a package")
+	}
+
+	if c.Output == "" {
+		return fmt.Errorf("plugin %s needs an output path", c.Package)
+	}
+
+	if filepath.IsAbs(c.Output) || strings.HasPrefix(filepath.Clean(c.Output), "..") {
+		return fmt.Errorf("plugin output %q must be relative to the app", c.Output)
+	}
+
+	return nil
+}
+
+// BuildPlugins builds each plugin with -buildmode=plugin, using the same
+// toolchain, tags and module graph as the main binary so that the plugin
+// and its host always agree on package versions.
+func (gf *Finalizer) BuildPlugins(plugins []PluginConfig) error {
+	gf.PluginOutputs = nil
+
+	if len(plugins) == 0 {
+		return nil
+	}
+
+	if os.Getenv("CGO_ENABLED") == "0" {
+		return errors.New("plugins need cgo, but CGO_ENABLED=0")
+	}
+
+	for _, plugin := range plugins {
+		output := filepath.Join(gf.Stager.BuildDir(), plugin.Output)
+		if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return err
+		}
+
+		args := []string{"build"}
+		args = append(args, gf.pluginBuildFlags()...)
+		args = append(args, "-o", output, plugin.Package)
+
+		if err := gf.runGo(args); err != nil {
+			return err
+		}
+
+		gf.PluginOutputs = append(gf.PluginOutputs, filepath.Clean(plugin.Output))
+	}
+
+	return nil
+}
+
+// pluginBuildFlags are the build flags with -buildmode pie replaced by
+// -buildmode plugin.
+func (gf *Finalizer) pluginBuildFlags() []string {
+	var flags []string
+	for i := 0; i < len(gf.BuildFlags); i++ {
+		if gf.BuildFlags[i] == "-buildmode" && i+1 < len(gf.BuildFlags) {
+			i++
+			continue
+		}
+		flags = append(flags, gf.BuildFlags[i])
+	}
+	return append(flags, "-buildmode", "plugin")
+}
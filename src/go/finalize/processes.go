@@ -0,0 +1,110 @@
+package finalize
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/go-buildpack/src/go/data"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+var (
+	processTypeName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	envVarName      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+type ProcessConfig struct {
+	Binary string            `yaml:"binary"`
+	Args   []string          `yaml:"args"`
+	Env    map[string]string `yaml:"env"`
+}
+
+func (c ProcessConfig) Validate() error {
+	if c.Binary != "" && (strings.ContainsAny(c.Binary, `/\`) || c.Binary == "." || c.Binary == "..") {
+		return fmt.Errorf("binary %q must be the name of a file in bin/", c.Binary)
+	}
+
+	for _, arg := range c.Args {
+		if strings.ContainsAny(arg, "\r\n") {
+			return fmt.Errorf("argument %q spans more than one line", arg)
+		}
+	}
+
+	for name, value := range c.Env {
+		if !envVarName.MatchString(name) {
+			return fmt.Errorf("%q is not a valid environment variable name", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("the value of %s spans more than one line", name)
+		}
+	}
+
+	return nil
+}
+
+// SetProcesses resolves the process types from buildpack.yml against the
+// binaries in <build-dir>/bin. A process without a binary runs the app's
+// main binary. Without any configured processes the app gets a single web
+// process.
+func (gf *Finalizer) SetProcesses(processes map[string]ProcessConfig) error {
+	gf.Processes = map[string]ProcessConfig{}
+
+	if len(processes) == 0 {
+		gf.Processes["web"] = ProcessConfig{Binary: gf.mainBinaryName()}
+		return nil
+	}
+
+	var names []string
+	for name := range processes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gf.Log.BeginStep("Setting process types from buildpack.yml")
+
+	for _, name := range names {
+		process := processes[name]
+		if process.Binary == "" {
+			process.Binary = gf.mainBinaryName()
+		}
+
+		exists, err := libbuildpack.FileExists(filepath.Join(gf.Stager.BuildDir(), "bin", process.Binary))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("process %s runs bin/%s, which was not built", name, process.Binary)
+		}
+
+		gf.Processes[name] = process
+		gf.Log.Info("%s: %s", name, gf.startCommand(name, process))
+	}
+
+	return nil
+}
+
+func (gf *Finalizer) startCommand(name string, process ProcessConfig) string {
+	command := data.BinaryCommand(process.Binary)
+	args := process.Args
+
+	if gf.DebugPort != 0 && name == "web" {
+		command = data.DebugCommand(process.Binary, gf.DebugPort)
+		if len(args) > 0 {
+			args = append([]string{"--"}, args...)
+		}
+	} else if gf.CrashCapture {
+		command = data.CrashCaptureCommand(process.Binary)
+	}
+
+	return data.StartCommand(command, args, process.Env)
+}
+
+func (gf *Finalizer) mainBinaryName() string {
+	if len(gf.PackageList) > 0 && gf.PackageList[0] != "." {
+		return filepath.Base(gf.PackageList[0])
+	}
+	return filepath.Base(gf.MainPackageName)
+}
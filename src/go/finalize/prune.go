@@ -0,0 +1,184 @@
+package finalize
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// prunedAlwaysKept are the top-level build dir entries the droplet needs to
+// launch, whatever buildpack.yml asks to keep.
+var prunedAlwaysKept = map[string]bool{
+	"bin":           true,
+	".profile.d":    true,
+	".profile":      true,
+	".cloudfoundry": true,
+	"Procfile":      true,
+}
+
+type PruneConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Keep lists paths or filepath.Match globs, relative to the app or to
+	// its module dir when one is set, that survive pruning. A pattern matching a directory keeps all of it.
+	Keep []string `yaml:"keep"`
+}
+
+func (c PruneConfig) Validate() error {
+	for _, pattern := range c.Keep {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid keep pattern %q: %s", pattern, err)
+		}
+		if filepath.IsAbs(pattern) || strings.HasPrefix(filepath.Clean(pattern), "..") {
+			return fmt.Errorf("keep pattern %q must be relative to the app", pattern)
+		}
+	}
+	return nil
+}
+
+// PruneBuildDir removes everything from the build dir that the compiled app
+// does not need at launch: Go sources, vendor/, test fixtures and, with
+// GO_SETUP_GOPATH_IN_IMAGE, the rest of the GOPATH. When the module lives in
+// a subdirectory, the rest of the tree goes too.
+func (gf *Finalizer) PruneBuildDir(config PruneConfig) error {
+	if !config.Enabled {
+		return nil
+	}
+
+	gf.Log.BeginStep("Pruning source code from the droplet")
+
+	buildDir := gf.Stager.BuildDir()
+	appDir := buildDir
+	if os.Getenv("GO_SETUP_GOPATH_IN_IMAGE") == "true" || gf.ModuleDir != "" {
+		appDir = gf.mainPackagePath()
+	}
+
+	var removed []string
+	var saved int64
+
+	err := filepath.WalkDir(buildDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == buildDir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(buildDir, path)
+		if err != nil {
+			return err
+		}
+		if prunedAlwaysKept[rel] || slices.Contains(gf.PluginOutputs, rel) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if appRel, err := filepath.Rel(appDir, path); err == nil && !strings.HasPrefix(appRel, "..") {
+			if keepPath(filepath.ToSlash(appRel), config.Keep) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			saved += info.Size()
+		}
+		removed = append(removed, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range removed {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	if err := removeEmptyDirs(buildDir, appDir); err != nil {
+		return err
+	}
+
+	gf.Log.Info("Removed %d files, saving %s", len(removed), humanSize(saved))
+	return nil
+}
+
+// keepPath reports whether rel, or any directory containing it, matches one
+// of the keep patterns.
+func keepPath(rel string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(filepath.ToSlash(filepath.Clean(pattern)), "/")
+		for candidate := rel; candidate != "." && candidate != "/"; candidate = filepath.ToSlash(filepath.Dir(candidate)) {
+			if matched, _ := filepath.Match(pattern, candidate); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeEmptyDirs removes the directories under root left empty by pruning,
+// deepest first, except for the always-kept entries and keepDir itself.
+func removeEmptyDirs(root, keepDir string) error {
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() || path == root {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if prunedAlwaysKept[rel] {
+			return filepath.SkipDir
+		}
+
+		dirs = append(dirs, path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i]) > len(dirs[j])
+	})
+
+	for _, dir := range dirs {
+		if dir == keepDir || strings.HasPrefix(keepDir, dir+string(filepath.Separator)) {
+			continue
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			if err := os.Remove(dir); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package finalize
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type ldflagTemplateData struct {
+	BuildTime      string
+	BuildTimestamp int64
+	GoVersion      string
+}
+
+// expandLDFlagValue renders template actions such as {{.BuildTime}} in an
+// ldflags value. Values without template actions are returned unchanged.
+func (gf *Finalizer) expandLDFlagValue(value string, reproducible bool) string {
+	if !strings.Contains(value, "{{") {
+		return value
+	}
+
+	tmpl, err := template.New("ldflags").Option("missingkey=error").Parse(value)
+	if err != nil {
+		gf.Log.Warning("Unable to parse ldflags value %q, using it as is: %s", value, err)
+		return value
+	}
+
+	buildTime := gf.buildTime(reproducible)
+	data := ldflagTemplateData{
+		BuildTime:      buildTime.Format(time.RFC3339),
+		BuildTimestamp: buildTime.Unix(),
+		GoVersion:      gf.GoVersion,
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := tmpl.Execute(buffer, data); err != nil {
+		gf.Log.Warning("Unable to render ldflags value %q, using it as is: %s", value, err)
+		return value
+	}
+
+	return buffer.String()
+}
+
+// buildTime honours SOURCE_DATE_EPOCH so that templated values are stable
+// across builds of the same source. Reproducible builds without it fall
+// back to the Unix epoch rather than the current time.
+func (gf *Finalizer) buildTime(reproducible bool) time.Time {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err == nil {
+			return time.Unix(seconds, 0).UTC()
+		}
+		gf.Log.Warning("Ignoring invalid SOURCE_DATE_EPOCH %q", epoch)
+	}
+
+	if reproducible {
+		return time.Unix(0, 0).UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (gf *Finalizer) LogBinaryChecksums() error {
+	binDir := filepath.Join(gf.Stager.BuildDir(), "bin")
+
+	files, err := os.ReadDir(binDir)
+	if err != nil {
+		return err
+	}
+
+	gf.Log.BeginStep("Binary checksums")
+	for _, file := range files {
+		if !file.Type().IsRegular() {
+			continue
+		}
+
+		sum, err := sha256File(filepath.Join(binDir, file.Name()))
+		if err != nil {
+			return err
+		}
+		gf.Log.Info("sha256:%s  bin/%s", sum, file.Name())
+	}
+
+	return nil
+}
+
+func sha256File(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
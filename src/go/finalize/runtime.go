@@ -0,0 +1,59 @@
+package finalize
+
+import (
+	"errors"
+	"sort"
+)
+
+const defaultMemoryHeadroom = 10
+
+type RuntimeConfig struct {
+	MemoryHeadroom *int   `yaml:"memory_headroom"`
+	GOGC           string `yaml:"gogc"`
+	GODEBUG        string `yaml:"godebug"`
+	GOTRACEBACK    string `yaml:"gotraceback"`
+}
+
+func (c RuntimeConfig) Validate() error {
+	if c.MemoryHeadroom != nil && (*c.MemoryHeadroom < 0 || *c.MemoryHeadroom > 99) {
+		return errors.New("memory_headroom must be a percentage between 0 and 99")
+	}
+
+	return nil
+}
+
+// SetRuntimeTuning records how the launch environment tunes the Go runtime
+// for the container. GOMEMLIMIT is derived from the memory limit less the
+// headroom and GOMAXPROCS from the CPU quota; the static defaults from
+// buildpack.yml are only applied when the app does not set them itself.
+func (gf *Finalizer) SetRuntimeTuning(config RuntimeConfig) {
+	gf.MemoryHeadroom = defaultMemoryHeadroom
+	if config.MemoryHeadroom != nil {
+		gf.MemoryHeadroom = *config.MemoryHeadroom
+	}
+
+	gf.RuntimeDefaults = map[string]string{}
+	for name, value := range map[string]string{
+		"GOGC":        config.GOGC,
+		"GODEBUG":     config.GODEBUG,
+		"GOTRACEBACK": config.GOTRACEBACK,
+	} {
+		if value != "" {
+			gf.RuntimeDefaults[name] = value
+		}
+	}
+
+	gf.Log.BeginStep("Setting GOMEMLIMIT at launch to the container memory limit less %d%% headroom", gf.MemoryHeadroom)
+	for _, name := range sortedKeys(gf.RuntimeDefaults) {
+		gf.Log.Info("Defaulting %s=%s", name, gf.RuntimeDefaults[name])
+	}
+}
+
+func sortedKeys(values map[string]string) []string {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
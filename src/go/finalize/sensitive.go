@@ -0,0 +1,175 @@
+package finalize
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/go-buildpack/src/go/warnings"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// defaultSensitivePatterns match files that commonly hold credentials.
+var defaultSensitivePatterns = []string{
+	".env",
+	".env.*",
+	"*.pem",
+	"*.key",
+	"*.p12",
+	"*.pfx",
+	"id_rsa",
+	"id_dsa",
+	"id_ecdsa",
+	"id_ed25519",
+	".netrc",
+	".git-credentials",
+	".aws/credentials",
+}
+
+// sensitivePolicies are ordered from least to most strict.
+var sensitivePolicies = []string{"warn", "strip", "fail"}
+
+type SensitiveFilesConfig struct {
+	Policy   string   `yaml:"policy"`
+	Patterns []string `yaml:"patterns"`
+}
+
+// LoadOperatorSensitiveFiles reads the sensitive_files section of the
+// buildpack's manifest.yml. Apps cannot change it, so it is where operators
+// enforce a policy.
+func LoadOperatorSensitiveFiles(buildpackDir string) (SensitiveFilesConfig, error) {
+	var manifest struct {
+		SensitiveFiles SensitiveFilesConfig `yaml:"sensitive_files"`
+	}
+	if err := libbuildpack.NewYAML().Load(filepath.Join(buildpackDir, "manifest.yml"), &manifest); err != nil {
+		return SensitiveFilesConfig{}, err
+	}
+	return manifest.SensitiveFiles, nil
+}
+
+// CheckSensitiveFiles scans the build dir for files that look like secrets.
+// The policy and patterns in the buildpack's manifest.yml are enforced: the
+// app can add patterns and make the policy stricter, but cannot relax it.
+// GO_SENSITIVE_FILES_POLICY and GO_SENSITIVE_FILES_PATTERNS, typically set in
+// the staging environment variable group, are only defaults, since an app's
+// own environment variables take precedence over the group.
+func (gf *Finalizer) CheckSensitiveFiles(config SensitiveFilesConfig) error {
+	policy, err := strictestPolicy(gf.SensitiveFiles.Policy, os.Getenv("GO_SENSITIVE_FILES_POLICY"), config.Policy)
+	if err != nil {
+		return err
+	}
+
+	patterns := append([]string{}, defaultSensitivePatterns...)
+	patterns = append(patterns, gf.SensitiveFiles.Patterns...)
+	patterns = append(patterns, strings.Fields(os.Getenv("GO_SENSITIVE_FILES_PATTERNS"))...)
+	patterns = append(patterns, config.Patterns...)
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid sensitive file pattern %q: %s", pattern, err)
+		}
+	}
+
+	buildDir := gf.Stager.BuildDir()
+	var found []string
+	err = filepath.WalkDir(buildDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(buildDir, path)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if rel == ".cloudfoundry" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if matchesSensitivePattern(filepath.ToSlash(rel), patterns) {
+			found = append(found, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(found) == 0 {
+		return nil
+	}
+
+	switch policy {
+	case "fail":
+		gf.Log.Error("%s", warnings.SensitiveFilesError(found))
+		return fmt.Errorf("found %d sensitive files", len(found))
+	case "strip":
+		for _, rel := range found {
+			if err := os.Remove(filepath.Join(buildDir, rel)); err != nil {
+				return err
+			}
+		}
+		gf.Log.Warning("%s", warnings.SensitiveFilesStripped(found))
+	default:
+		gf.Log.Warning("%s", warnings.SensitiveFilesWarning(found))
+	}
+
+	return nil
+}
+
+// matchesSensitivePattern matches patterns without a slash against the
+// base name of rel, and other patterns against each trailing run of path
+// segments of rel. A leading slash anchors a pattern to the build dir.
+func matchesSensitivePattern(rel string, patterns []string) bool {
+	segments := strings.Split(rel, "/")
+
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			if matched, _ := filepath.Match(pattern, segments[len(segments)-1]); matched {
+				return true
+			}
+			continue
+		}
+
+		if strings.HasPrefix(pattern, "/") {
+			if matched, _ := filepath.Match(strings.TrimPrefix(pattern, "/"), rel); matched {
+				return true
+			}
+			continue
+		}
+
+		for i := range segments {
+			if matched, _ := filepath.Match(pattern, strings.Join(segments[i:], "/")); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func strictestPolicy(policies ...string) (string, error) {
+	strictest := 0
+	for _, policy := range policies {
+		if policy == "" {
+			continue
+		}
+
+		index := -1
+		for i, p := range sensitivePolicies {
+			if p == policy {
+				index = i
+			}
+		}
+		if index == -1 {
+			return "", fmt.Errorf("unknown sensitive files policy %q, must be one of %s", policy, strings.Join(sensitivePolicies, ", "))
+		}
+
+		if index > strictest {
+			strictest = index
+		}
+	}
+	return sensitivePolicies[strictest], nil
+}
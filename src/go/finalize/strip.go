@@ -0,0 +1,232 @@
+package finalize
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// maxCachedDebugBuilds bounds how many full-symbol builds are kept in the
+// app cache dir when stripped binaries keep their symbols there.
+const maxCachedDebugBuilds = 10
+
+type StripConfig struct {
+	// Mode is "linker" to build with -s -w, or "post" to strip the
+	// binaries after they have been built.
+	Mode string `yaml:"mode"`
+	// Symbols is where the full-symbol copies of post-stripped binaries
+	// are kept: "cache" for the app cache dir, or an absolute directory.
+	Symbols string `yaml:"symbols"`
+}
+
+func (c StripConfig) Validate() error {
+	switch c.Mode {
+	case "", "linker", "post":
+	default:
+		return fmt.Errorf("unknown strip mode %q, must be one of linker or post", c.Mode)
+	}
+
+	if c.Symbols != "" && c.Mode != "post" {
+		return errors.New("symbols can only be kept when the strip mode is post")
+	}
+
+	if c.Symbols != "" && c.Symbols != "cache" && !filepath.IsAbs(c.Symbols) {
+		return fmt.Errorf("symbols directory %q must be 'cache' or an absolute path", c.Symbols)
+	}
+
+	return nil
+}
+
+// StripBinaries strips every binary under <build-dir>/bin in post mode and
+// reports the size of each binary before and after. In linker mode the
+// binaries are never built with symbols, so there is no before size and only
+// the final size is reported. Full-symbol copies are stored under the
+// SHA-256 of the stripped binary, so that a binary taken from a droplet can
+// be matched to its symbols.
+func (gf *Finalizer) StripBinaries(config StripConfig) error {
+	if config.Mode == "" {
+		return nil
+	}
+
+	binDir := filepath.Join(gf.Stager.BuildDir(), "bin")
+	files, err := os.ReadDir(binDir)
+	if err != nil {
+		return err
+	}
+
+	symbolsDir := config.Symbols
+	if symbolsDir == "cache" {
+		symbolsDir = filepath.Join(gf.Stager.CacheDir(), "debug-symbols")
+	}
+
+	gf.Log.BeginStep("Stripping binaries")
+
+	for _, file := range files {
+		if !file.Type().IsRegular() {
+			continue
+		}
+		binary := filepath.Join(binDir, file.Name())
+
+		// Apps may commit scripts or other files to bin/, which strip
+		// cannot handle.
+		if elf, err := isELF(binary); err != nil {
+			return err
+		} else if !elf {
+			continue
+		}
+
+		if config.Mode == "linker" {
+			info, err := os.Stat(binary)
+			if err != nil {
+				return err
+			}
+			gf.Log.Info("bin/%s: %s (stripped at link time, no unstripped size to compare)", file.Name(), humanSize(info.Size()))
+			continue
+		}
+
+		before, err := os.Stat(binary)
+		if err != nil {
+			return err
+		}
+
+		unstripped := binary + ".debug"
+		if err := os.Rename(binary, unstripped); err != nil {
+			return err
+		}
+
+		if err := gf.Command.Execute(binDir, os.Stdout, os.Stderr, "strip", "--strip-all", "-o", binary, unstripped); err != nil {
+			os.Rename(unstripped, binary)
+			return err
+		}
+
+		after, err := os.Stat(binary)
+		if err != nil {
+			return err
+		}
+		gf.Log.Info("bin/%s: %s -> %s", file.Name(), humanSize(before.Size()), humanSize(after.Size()))
+
+		if symbolsDir == "" {
+			if err := os.Remove(unstripped); err != nil {
+				return err
+			}
+			continue
+		}
+
+		sum, err := sha256File(binary)
+		if err != nil {
+			return err
+		}
+
+		dest := filepath.Join(symbolsDir, sum, file.Name())
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := moveFile(unstripped, dest); err != nil {
+			return err
+		}
+		gf.Log.Info("Kept symbols for bin/%s in %s", file.Name(), dest)
+	}
+
+	if config.Symbols == "cache" {
+		return pruneDebugBuilds(symbolsDir, maxCachedDebugBuilds)
+	}
+
+	return nil
+}
+
+func isELF(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(elf.ELFMAG))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return string(magic) == elf.ELFMAG, nil
+}
+
+func moveFile(src, dest string) error {
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+
+	if err := copyFile(src, dest); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func copyFile(src, dest string) error {
+	contents, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(dest, contents, info.Mode())
+}
+
+func pruneDebugBuilds(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	type build struct {
+		name    string
+		modTime int64
+	}
+
+	var builds []build
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		builds = append(builds, build{entry.Name(), info.ModTime().UnixNano()})
+	}
+
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].modTime > builds[j].modTime
+	})
+
+	for i := keep; i < len(builds); i++ {
+		if err := os.RemoveAll(filepath.Join(dir, builds[i].name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,131 @@
+package finalize
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// InstallTools builds the tools declared with tool directives in go.mod and
+// the module@version tools listed in buildpack.yml into <build-dir>/bin,
+// which go.sh puts on the PATH at launch. They are built with the same
+// module cache and proxy settings as the app.
+func (gf *Finalizer) InstallTools(tools []string) error {
+	hasToolDirectives := false
+	if gf.VendorTool == "gomod" {
+		var err error
+		hasToolDirectives, err = goModHasTools(filepath.Join(gf.mainPackagePath(), "go.mod"))
+		if err != nil {
+			return err
+		}
+	}
+
+	if !hasToolDirectives && len(tools) == 0 {
+		return nil
+	}
+
+	tmpDir, err := os.MkdirTemp("", "gobuildpack.tools")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if hasToolDirectives {
+		gf.Log.BeginStep("Building tools from go.mod")
+		if err := gf.runGo([]string{"build", "-o", tmpDir + string(filepath.Separator), "tool"}); err != nil {
+			return err
+		}
+	}
+
+	if len(tools) > 0 {
+		if err := gf.installPinnedTools(tools, tmpDir); err != nil {
+			return err
+		}
+	}
+
+	files, err := os.ReadDir(tmpDir)
+	if err != nil {
+		return err
+	}
+
+	binDir := filepath.Join(gf.Stager.BuildDir(), "bin")
+	for _, file := range files {
+		dest := filepath.Join(binDir, file.Name())
+		if exists, err := libbuildpack.FileExists(dest); err != nil {
+			return err
+		} else if exists {
+			return fmt.Errorf("tool %s would overwrite bin/%s", file.Name(), file.Name())
+		}
+
+		if err := moveFile(filepath.Join(tmpDir, file.Name()), dest); err != nil {
+			return err
+		}
+		gf.Log.Info("Installed tool bin/%s", file.Name())
+	}
+
+	return nil
+}
+
+// installPinnedTools runs go install module@version for each tool. Such
+// installs ignore the app's go.mod, so a -mod=vendor GOFLAGS set for the app
+// is lifted while they run.
+func (gf *Finalizer) installPinnedTools(tools []string, dir string) error {
+	goFlags, goFlagsSet := os.LookupEnv("GOFLAGS")
+	goBin := os.Getenv("GOBIN")
+	defer func() {
+		if goFlagsSet {
+			os.Setenv("GOFLAGS", goFlags)
+		}
+		os.Setenv("GOBIN", goBin)
+	}()
+
+	if err := os.Unsetenv("GOFLAGS"); err != nil {
+		return err
+	}
+	if err := os.Setenv("GOBIN", dir); err != nil {
+		return err
+	}
+
+	for _, tool := range tools {
+		args := []string{"install", tool}
+		gf.Log.BeginStep("Running: go %s", strings.Join(args, " "))
+		if err := gf.Command.Execute(gf.mainPackagePath(), os.Stdout, os.Stderr, "go", args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func goModHasTools(goModPath string) (bool, error) {
+	file, err := os.Open(goModPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[0] == "tool" {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+func validateTool(tool string) error {
+	name, version, found := strings.Cut(tool, "@")
+	if !found || name == "" || version == "" || version == "latest" {
+		return fmt.Errorf("tool %q must be pinned as module@version", tool)
+	}
+	return nil
+}
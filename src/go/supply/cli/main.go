@@ -55,6 +55,7 @@ func main() {
 		Log:       logger,
 		Manifest:  manifest,
 		Installer: installer,
+		Command:   &libbuildpack.Command{},
 	}
 
 	if err := supply.Run(&gs); err != nil {
@@ -4,18 +4,25 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"slices"
 	"strings"
 
 	"github.com/Masterminds/semver"
+	"github.com/cloudfoundry/go-buildpack/src/go/buildpackyml"
 	"github.com/cloudfoundry/go-buildpack/src/go/data"
 	"github.com/cloudfoundry/go-buildpack/src/go/godep"
 	"github.com/cloudfoundry/go-buildpack/src/go/warnings"
 	"github.com/cloudfoundry/libbuildpack"
 )
 
+type Command interface {
+	Execute(string, io.Writer, io.Writer, string, ...string) error
+}
+
 type Manifest interface {
 	AllDependencyVersions(string) []string
 	DefaultVersion(string) (libbuildpack.Dependency, error)
@@ -40,10 +47,12 @@ type Supplier struct {
 	Stager     Stager
 	Manifest   Manifest
 	Installer  Installer
+	Command    Command
 	Log        *libbuildpack.Logger
 	VendorTool string
 	GoVersion  string
 	Godep      godep.Godep
+	ModuleDir  string
 }
 
 func Run(gs *Supplier) error {
@@ -67,6 +76,16 @@ func Run(gs *Supplier) error {
 		return err
 	}
 
+	if err := gs.InstallDelve(); err != nil {
+		gs.Log.Error("Error installing Delve: %s", err.Error())
+		return err
+	}
+
+	if err := gs.BuildSupplyPackages(); err != nil {
+		gs.Log.Error("Unable to compile supply packages: %s", err.Error())
+		return err
+	}
+
 	if err := gs.WriteGoRootToProfileD(); err != nil {
 		gs.Log.Error("Error writing GOROOT to profile.d: %s", err.Error())
 		return err
@@ -93,12 +112,23 @@ func (gs *Supplier) SelectVendorTool() error {
 		return errors.New(".godir deprecated")
 	}
 
-	if exists, err := libbuildpack.FileExists(filepath.Join(gs.Stager.BuildDir(), "go.mod")); err != nil {
+	moduleDir, err := buildpackyml.ModuleDir(gs.Stager.BuildDir())
+	if err != nil {
+		return err
+	}
+	gs.ModuleDir = moduleDir
+
+	if exists, err := libbuildpack.FileExists(filepath.Join(gs.modulePath(), "go.mod")); err != nil {
 		return err
 	} else if exists {
+		if gs.ModuleDir != "" {
+			gs.Log.BeginStep("Building the Go module in %s", gs.ModuleDir)
+		}
 		gs.Stager.WriteEnvFile("GO111MODULE", "on")
 		gs.VendorTool = "gomod"
 		return nil
+	} else if gs.ModuleDir != "" {
+		return fmt.Errorf("module dir %s has no go.mod", gs.ModuleDir)
 	}
 
 	isGodep, err := libbuildpack.FileExists(godepsJSONFile)
@@ -144,6 +174,21 @@ func (gs *Supplier) SelectVendorTool() error {
 		return nil
 	}
 
+	isGoPath, err := gs.isGoPath()
+	if err != nil {
+		return err
+	}
+	if isGoPath && os.Getenv("GOPACKAGENAME") == "" {
+		hasRootGoFiles, err := hasGoFiles(gs.Stager.BuildDir())
+		if err != nil {
+			return err
+		}
+		if !hasRootGoFiles {
+			gs.VendorTool = "gopath"
+			return nil
+		}
+	}
+
 	gs.VendorTool = "go_nativevendoring"
 	return nil
 }
@@ -213,7 +258,7 @@ func (gs *Supplier) SelectGoVersion() error {
 			return fmt.Errorf("go version %s does not support go modules", gs.GoVersion)
 		}
 
-		if exists, err := libbuildpack.FileExists(filepath.Join(gs.Stager.BuildDir(), "vendor")); err != nil {
+		if exists, err := libbuildpack.FileExists(filepath.Join(gs.modulePath(), "vendor")); err != nil {
 			return err
 		} else if exists {
 			gs.Stager.WriteEnvFile("GOFLAGS", "-mod=vendor")
@@ -242,10 +287,125 @@ func (gs *Supplier) InstallGo() error {
 	return gs.Stager.WriteEnvFile("GOROOT", filepath.Join(goInstallDir))
 }
 
+// InstallDelve installs the Delve debugger when buildpack.yml enables the
+// debug build profile. Finalize moves it into the droplet.
+func (gs *Supplier) InstallDelve() error {
+	var config struct {
+		Go struct {
+			Debug struct {
+				Enabled bool `yaml:"enabled"`
+			} `yaml:"debug"`
+		} `yaml:"go"`
+	}
+
+	if err := gs.loadBuildpackYAML(&config); err != nil {
+		return err
+	}
+
+	if !config.Go.Debug.Enabled {
+		return nil
+	}
+
+	if len(gs.Manifest.AllDependencyVersions("dlv")) == 0 {
+		return errors.New("the debug build profile needs the dlv dependency, which is not in this buildpack's manifest")
+	}
+
+	installDir := filepath.Join(gs.Stager.DepDir(), "dlv")
+	if err := gs.Installer.InstallOnlyVersion("dlv", installDir); err != nil {
+		return err
+	}
+
+	return gs.Stager.AddBinDependencyLink(filepath.Join(installDir, "bin", "dlv"), "dlv")
+}
+
+// BuildSupplyPackages compiles the packages listed under go.supply.packages
+// in buildpack.yml into the deps dir and links them onto the PATH, so a
+// later buildpack's app can run them without the Go toolchain.
+func (gs *Supplier) BuildSupplyPackages() error {
+	var config struct {
+		Go struct {
+			Supply struct {
+				Packages []string `yaml:"packages"`
+			} `yaml:"supply"`
+		} `yaml:"go"`
+	}
+
+	if err := gs.loadBuildpackYAML(&config); err != nil {
+		return err
+	}
+
+	packages := config.Go.Supply.Packages
+	if len(packages) == 0 {
+		return nil
+	}
+
+	if gs.VendorTool != "gomod" {
+		return fmt.Errorf("supply packages are only supported for go modules apps, not %s", gs.VendorTool)
+	}
+
+	for _, pkg := range packages {
+		if name := supplyBinaryName(pkg); name == "" {
+			return fmt.Errorf("cannot name a binary for supply package %q", pkg)
+		}
+	}
+
+	gs.Log.BeginStep("Compiling supply packages")
+
+	goBin := filepath.Join(gs.Stager.DepDir(), "go"+gs.GoVersion, "bin", "go")
+	binDir := filepath.Join(gs.Stager.DepDir(), "supply", "bin")
+
+	vendored, err := libbuildpack.FileExists(filepath.Join(gs.modulePath(), "vendor"))
+	if err != nil {
+		return err
+	}
+
+	for _, pkg := range packages {
+		name := supplyBinaryName(pkg)
+		output := filepath.Join(binDir, name)
+
+		args := []string{"build"}
+		if vendored {
+			args = append(args, "-mod=vendor")
+		}
+		args = append(args, "-o", output, pkg)
+
+		gs.Log.Info("Running: go %s", strings.Join(args, " "))
+		if err := gs.Command.Execute(gs.modulePath(), os.Stdout, os.Stderr, goBin, args...); err != nil {
+			return err
+		}
+
+		if err := gs.Stager.AddBinDependencyLink(output, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func supplyBinaryName(pkg string) string {
+	name := path.Base(strings.TrimSuffix(pkg, "/..."))
+	if name == "." || name == ".." || name == "/" || strings.Contains(pkg, "...") {
+		return ""
+	}
+	return name
+}
+
+// modulePath is the directory holding go.mod, the build dir unless
+// buildpack.yml or $GO_MODULE_DIR moves it.
+func (gs *Supplier) modulePath() string {
+	return filepath.Join(gs.Stager.BuildDir(), gs.ModuleDir)
+}
+
+func (gs *Supplier) loadBuildpackYAML(config interface{}) error {
+	_, _, err := buildpackyml.Load(gs.Stager.BuildDir(), config)
+	return err
+}
+
 func (gs *Supplier) WriteConfigYml() error {
 	config := map[string]string{
 		"GoVersion":  gs.GoVersion,
 		"VendorTool": gs.VendorTool,
+		"ModuleDir":  gs.ModuleDir,
 	}
 
 	if gs.VendorTool == "godep" {
@@ -316,6 +476,24 @@ func (gs *Supplier) isGoPath() (bool, error) {
 	return false, nil
 }
 
+// hasGoFiles reports whether dir itself, not its subdirectories, holds Go
+// files. An app with Go files next to src/ is a native vendoring app whose
+// packages happen to live under src, not a GOPATH.
+func hasGoFiles(dir string) (bool, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".go") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 func isGoFile(path string, info os.FileInfo, err error) error {
 	if err != nil {
 		return err
@@ -30,9 +30,11 @@ var _ = Describe("Supply", func() {
 		mockCtrl      *gomock.Controller
 		mockManifest  *MockManifest
 		mockInstaller *MockInstaller
+		mockCommand   *MockCommand
 		goVersion     string
 		vendorTool    string
 		godepConfig   godep.Godep
+		moduleDir     string
 	)
 
 	BeforeEach(func() {
@@ -59,6 +61,7 @@ var _ = Describe("Supply", func() {
 		mockCtrl = gomock.NewController(GinkgoT())
 		mockManifest = NewMockManifest(mockCtrl)
 		mockInstaller = NewMockInstaller(mockCtrl)
+		mockCommand = NewMockCommand(mockCtrl)
 
 		DeferCleanup(func() {
 			Expect(os.RemoveAll(bpDir)).To(Succeed())
@@ -77,10 +80,12 @@ var _ = Describe("Supply", func() {
 			Stager:     stager,
 			Manifest:   mockManifest,
 			Installer:  mockInstaller,
+			Command:    mockCommand,
 			Log:        logger,
 			GoVersion:  goVersion,
 			VendorTool: vendorTool,
 			Godep:      godepConfig,
+			ModuleDir:  moduleDir,
 		}
 	})
 
@@ -207,6 +212,38 @@ var _ = Describe("Supply", func() {
 
 		})
 
+		Context("GO_MODULE_DIR points into the app", func() {
+			BeforeEach(func() {
+				DeferCleanup(os.Setenv, "GO_MODULE_DIR", os.Getenv("GO_MODULE_DIR"))
+				Expect(os.Setenv("GO_MODULE_DIR", "services/api")).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(buildDir, "services", "api"), 0755)).To(Succeed())
+			})
+
+			Context("the module dir has a go.mod file", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "services", "api", "go.mod"), []byte("xxx"), 0666)).To(Succeed())
+				})
+
+				It("sets the tool to gomod and remembers the module dir", func() {
+					Expect(gs.SelectVendorTool()).To(Succeed())
+
+					Expect(gs.VendorTool).To(Equal("gomod"))
+					Expect(gs.ModuleDir).To(Equal(filepath.Join("services", "api")))
+					Expect(buffer.String()).To(ContainSubstring("-----> Building the Go module in services/api"))
+				})
+			})
+
+			Context("only the build dir has a go.mod file", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "go.mod"), []byte("xxx"), 0666)).To(Succeed())
+				})
+
+				It("returns an error", func() {
+					Expect(gs.SelectVendorTool()).To(MatchError("module dir services/api has no go.mod"))
+				})
+			})
+		})
+
 		Context("there is a .godir file", func() {
 			BeforeEach(func() {
 				err = os.WriteFile(filepath.Join(buildDir, ".godir"), []byte("xxx"), 0644)
@@ -242,6 +279,38 @@ var _ = Describe("Supply", func() {
 
 				err = os.WriteFile(filepath.Join(buildDir, "src", "package", "thing.go"), []byte("xxx"), 0644)
 				Expect(err).To(BeNil())
+
+				DeferCleanup(os.Setenv, "GOPACKAGENAME", os.Getenv("GOPACKAGENAME"))
+				Expect(os.Unsetenv("GOPACKAGENAME")).To(Succeed())
+			})
+
+			It("sets the tool to gopath", func() {
+				err = gs.SelectVendorTool()
+				Expect(err).To(BeNil())
+
+				Expect(gs.VendorTool).To(Equal("gopath"))
+			})
+
+			Context("the app also has Go files at its root", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "main.go"), []byte("xxx"), 0644)).To(Succeed())
+				})
+
+				It("sets the tool to go_nativevendoring", func() {
+					Expect(gs.SelectVendorTool()).To(Succeed())
+					Expect(gs.VendorTool).To(Equal("go_nativevendoring"))
+				})
+			})
+
+			Context("GOPACKAGENAME is set", func() {
+				BeforeEach(func() {
+					Expect(os.Setenv("GOPACKAGENAME", "example.com/app")).To(Succeed())
+				})
+
+				It("sets the tool to go_nativevendoring", func() {
+					Expect(gs.SelectVendorTool()).To(Succeed())
+					Expect(gs.VendorTool).To(Equal("go_nativevendoring"))
+				})
 			})
 		})
 		Context("there is a Gopkg.toml", func() {
@@ -297,6 +366,140 @@ var _ = Describe("Supply", func() {
 		})
 	})
 
+	Describe("InstallDelve", func() {
+		Context("there is no buildpack.yml", func() {
+			It("does not install Delve", func() {
+				Expect(gs.InstallDelve()).To(Succeed())
+			})
+		})
+
+		Context("the debug profile is enabled", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("go:\n  debug:\n    enabled: true\n"), 0644)).To(Succeed())
+			})
+
+			It("installs Delve and links it into the deps bin dir", func() {
+				installDir := filepath.Join(depsDir, depsIdx, "dlv")
+				mockManifest.EXPECT().AllDependencyVersions("dlv").Return([]string{"1.23.1"})
+				mockInstaller.EXPECT().InstallOnlyVersion("dlv", installDir).Do(func(_, dir string) {
+					Expect(os.MkdirAll(filepath.Join(dir, "bin"), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(dir, "bin", "dlv"), []byte("dlv"), 0755)).To(Succeed())
+				}).Return(nil)
+
+				Expect(gs.InstallDelve()).To(Succeed())
+
+				Expect(filepath.Join(depsDir, depsIdx, "bin", "dlv")).To(BeAnExistingFile())
+			})
+
+			It("returns an error when the manifest has no Delve", func() {
+				mockManifest.EXPECT().AllDependencyVersions("dlv").Return([]string{})
+
+				Expect(gs.InstallDelve()).To(MatchError(ContainSubstring("needs the dlv dependency")))
+			})
+		})
+
+		Context("the selected build profile enables debugging", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("go:\n  profiles:\n    dev:\n      debug:\n        enabled: true\n"), 0644)).To(Succeed())
+
+				oldProfile := os.Getenv("GO_BUILD_PROFILE")
+				Expect(os.Setenv("GO_BUILD_PROFILE", "dev")).To(Succeed())
+				DeferCleanup(os.Setenv, "GO_BUILD_PROFILE", oldProfile)
+			})
+
+			It("installs Delve", func() {
+				mockManifest.EXPECT().AllDependencyVersions("dlv").Return([]string{"1.23.1"})
+				mockInstaller.EXPECT().InstallOnlyVersion("dlv", filepath.Join(depsDir, depsIdx, "dlv")).Return(nil)
+
+				Expect(gs.InstallDelve()).To(Succeed())
+			})
+		})
+	})
+
+	Describe("BuildSupplyPackages", func() {
+		BeforeEach(func() {
+			goVersion = "1.22.5"
+			vendorTool = "gomod"
+		})
+
+		Context("there is no buildpack.yml", func() {
+			It("does not compile anything", func() {
+				Expect(gs.BuildSupplyPackages()).To(Succeed())
+			})
+		})
+
+		Context("buildpack.yml lists supply packages", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("go:\n  supply:\n    packages:\n    - ./cmd/migrate\n"), 0644)).To(Succeed())
+			})
+
+			It("builds them into the deps dir and links them into the deps bin dir", func() {
+				goBin := filepath.Join(depsDir, depsIdx, "go1.22.5", "bin", "go")
+				output := filepath.Join(depsDir, depsIdx, "supply", "bin", "migrate")
+				mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), goBin, "build", "-o", output, "./cmd/migrate").Do(func(_ string, _, _ interface{}, _ string, _ ...string) {
+					Expect(os.MkdirAll(filepath.Dir(output), 0755)).To(Succeed())
+					Expect(os.WriteFile(output, []byte("migrate"), 0755)).To(Succeed())
+				}).Return(nil)
+
+				Expect(gs.BuildSupplyPackages()).To(Succeed())
+
+				Expect(buffer.String()).To(ContainSubstring("-----> Compiling supply packages"))
+				Expect(buffer.String()).To(ContainSubstring("Running: go build -o " + output + " ./cmd/migrate"))
+				Expect(filepath.Join(depsDir, depsIdx, "bin", "migrate")).To(BeAnExistingFile())
+			})
+
+			Context("the app is vendored", func() {
+				BeforeEach(func() {
+					Expect(os.MkdirAll(filepath.Join(buildDir, "vendor"), 0755)).To(Succeed())
+				})
+
+				It("builds with -mod=vendor", func() {
+					goBin := filepath.Join(depsDir, depsIdx, "go1.22.5", "bin", "go")
+					output := filepath.Join(depsDir, depsIdx, "supply", "bin", "migrate")
+					mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), goBin, "build", "-mod=vendor", "-o", output, "./cmd/migrate").Return(nil)
+
+					Expect(gs.BuildSupplyPackages()).To(Succeed())
+				})
+			})
+
+			Context("the module is in a subdirectory", func() {
+				BeforeEach(func() {
+					moduleDir = filepath.Join("services", "api")
+					DeferCleanup(func() { moduleDir = "" })
+					Expect(os.MkdirAll(filepath.Join(buildDir, "services", "api", "vendor"), 0755)).To(Succeed())
+				})
+
+				It("builds from the module dir, using its vendor dir", func() {
+					goBin := filepath.Join(depsDir, depsIdx, "go1.22.5", "bin", "go")
+					output := filepath.Join(depsDir, depsIdx, "supply", "bin", "migrate")
+					mockCommand.EXPECT().Execute(filepath.Join(buildDir, "services", "api"), gomock.Any(), gomock.Any(), goBin, "build", "-mod=vendor", "-o", output, "./cmd/migrate").Return(nil)
+
+					Expect(gs.BuildSupplyPackages()).To(Succeed())
+				})
+			})
+
+			Context("the app does not use go modules", func() {
+				BeforeEach(func() {
+					vendorTool = "dep"
+				})
+
+				It("returns an error", func() {
+					Expect(gs.BuildSupplyPackages()).To(MatchError("supply packages are only supported for go modules apps, not dep"))
+				})
+			})
+		})
+
+		Context("a supply package has no usable binary name", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("go:\n  supply:\n    packages:\n    - ./...\n"), 0644)).To(Succeed())
+			})
+
+			It("returns an error", func() {
+				Expect(gs.BuildSupplyPackages()).To(MatchError(`cannot name a binary for supply package "./..."`))
+			})
+		})
+	})
+
 	Describe("SelectGoVersion", func() {
 		BeforeEach(func() {
 			versions := []string{"1.8.0", "1.7.5", "1.7.4", "1.6.3", "1.6.4", "34.34.0", "1.14.3"}
@@ -474,6 +677,7 @@ var _ = Describe("Supply", func() {
 				GoVersion  string `yaml:"GoVersion"`
 				VendorTool string `yaml:"VendorTool"`
 				Godep      string `yaml:"Godep"`
+				ModuleDir  string `yaml:"ModuleDir"`
 			} `yaml:"config"`
 		}
 		getConfig := func() config {
@@ -549,5 +753,20 @@ var _ = Describe("Supply", func() {
 				Expect(cfg.Config.Godep).To(Equal(""))
 			})
 		})
+
+		Context("The module is in a subdirectory", func() {
+			BeforeEach(func() {
+				vendorTool = "gomod"
+				moduleDir = filepath.Join("services", "api")
+				DeferCleanup(func() { moduleDir = "" })
+			})
+
+			It("Writes the module dir to config.yml", func() {
+				Expect(gs.WriteConfigYml()).To(Succeed())
+
+				cfg := getConfig()
+				Expect(cfg.Config.ModuleDir).To(Equal(filepath.Join("services", "api")))
+			})
+		})
 	})
 })
@@ -34,7 +34,8 @@ See https://github.com/tools/godep or https://github.com/Masterminds/glide for u
 
 func NoGOPACKAGENAMEerror() string {
 	errorMessage := `To use go native vendoring set the $GOPACKAGENAME
-environment variable to your app's package name`
+environment variable to your app's package name, or declare it with a
+canonical import comment such as: package main // import "example.com/app"`
 
 	return errorMessage
 }
@@ -59,3 +60,39 @@ with go 1.6 this environment variable must unset or set to 1.`
 
 	return errorMessage
 }
+
+func SensitiveFilesWarning(paths []string) string {
+	warning := `The following files look like they contain secrets and will be part of the droplet:
+    %s
+
+Remove them from the app or list them in .cfignore before pushing again.`
+
+	return fmt.Sprintf(warning, strings.Join(paths, "\n    "))
+}
+
+func SensitiveFilesStripped(paths []string) string {
+	warning := `Removed the following files because they look like they contain secrets:
+    %s`
+
+	return fmt.Sprintf(warning, strings.Join(paths, "\n    "))
+}
+
+func SensitiveFilesError(paths []string) string {
+	errorMessage := `Staging stopped because the following files look like they contain secrets:
+    %s
+
+Remove them from the app or list them in .cfignore before pushing again.`
+
+	return fmt.Sprintf(errorMessage, strings.Join(paths, "\n    "))
+}
+
+func MultipleMainPackagesError(packages []string) string {
+	errorMessage := `This module has no main package at its root and several under it:
+    %s
+
+Set processes.web.binary in buildpack.yml to the one web should run, or
+list the packages to build under packages in buildpack.yml or in
+$GO_INSTALL_PACKAGE_SPEC.`
+
+	return fmt.Sprintf(errorMessage, strings.Join(packages, "\n    "))
+}